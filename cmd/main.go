@@ -14,7 +14,7 @@ var sqlLanguageWasm []byte
 //go:embed sql.highlights.scm
 var sqlHighlightsQuery string
 
-var q = `select time_id, product
+var src = `select time_id, product
    , last_value(quantity ignore nulls) over (partition by product order by time_id) quantity
    , last_value(quantity respect nulls) over (partition by product order by time_id) quantity
    from ( select times.time_id, product, quantity 
@@ -97,6 +97,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	defer ts.Close(ctx)
 	p, err := ts.NewParser(ctx)
 	if err != nil {
 		panic(err)
@@ -125,10 +126,11 @@ func main() {
 		panic(err)
 	}
 
-	tree, err := p.ParseString(ctx, q)
+	tree, err := p.ParseString(ctx, src)
 	if err != nil {
 		panic(err)
 	}
+	defer tree.Close(ctx)
 	root, err := tree.RootNode(ctx)
 	if err != nil {
 		panic(err)
@@ -196,50 +198,49 @@ func main() {
 	}
 	log.Printf("child 2 string: %+v\n", child2String)
 
-	q, err := ts.NewQuery(ctx, sqlHighlightsQuery, sqlLang)
+	q, err := ts.NewQuery(ctx, sqlHighlightsQuery, sqlLang, treesittergo.QueryOptions{LuaPatterns: true})
 	if err != nil {
 		panic(err)
 	}
+	defer q.Close(ctx)
 	qc, err := ts.NewQueryCursor(ctx)
 	if err != nil {
 		panic(err)
 	}
-	qc.Exec(ctx, q, child1child1)
+	defer qc.Close(ctx)
+	qc.ExecWithText(ctx, q, child1child1, []byte(src))
 	lastEnd := uint64(0)
-	// Iterate over query results
+	// Iterate over captures in document order, keeping the first capture
+	// of overlapping ones
 	for {
-		m, ok, err := qc.NextMatch(ctx)
+		m, i, ok, err := qc.NextCapture(ctx)
 		if err != nil {
 			panic(err)
 		}
 		if !ok {
 			break
 		}
-		if m.Captures == nil {
+		c := m.Captures[i]
+		nodeStartByte, err := c.Node.StartByte(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if nodeStartByte < lastEnd {
 			continue
 		}
-		for _, c := range m.Captures {
-			nodeStartByte, err := c.Node.StartByte(ctx)
-			if err != nil {
-				panic(err)
-			}
-			if nodeStartByte < lastEnd {
-				continue
-			}
-			captureName, err := q.CaptureNameForID(ctx, c.ID)
-			if err != nil {
-				panic(err)
-			}
-			nodeEndByte, err := c.Node.EndByte(ctx)
-			if err != nil {
-				panic(err)
-			}
-			nodeStr, err := c.Node.String(ctx)
-			if err != nil {
-				panic(err)
-			}
-			log.Printf("(%d-%d) %s: %s\n", nodeStartByte, nodeEndByte, captureName, nodeStr)
-			lastEnd = nodeEndByte
+		captureName, err := q.CaptureNameForID(ctx, c.ID)
+		if err != nil {
+			panic(err)
+		}
+		nodeEndByte, err := c.Node.EndByte(ctx)
+		if err != nil {
+			panic(err)
+		}
+		nodeStr, err := c.Node.String(ctx)
+		if err != nil {
+			panic(err)
 		}
+		log.Printf("(%d-%d) %s: %s\n", nodeStartByte, nodeEndByte, captureName, nodeStr)
+		lastEnd = nodeEndByte
 	}
 }
@@ -0,0 +1,133 @@
+package treesittergo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// InputEncoding is the encoding of a document passed to ParseBytesEncoding.
+type InputEncoding int
+
+const (
+	InputEncodingUTF8 InputEncoding = iota
+	InputEncodingUTF16LE
+	InputEncodingUTF16BE
+	InputEncodingLatin1
+)
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+func (e InputEncoding) String() string {
+	switch e {
+	case InputEncodingUTF8:
+		return "UTF-8"
+	case InputEncodingUTF16LE:
+		return "UTF-16LE"
+	case InputEncodingUTF16BE:
+		return "UTF-16BE"
+	case InputEncodingLatin1:
+		return "Latin-1"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectEncoding returns the encoding of b from its byte order mark. Without
+// one, b is UTF-8 if it is valid UTF-8 and Latin-1 otherwise.
+func DetectEncoding(b []byte) InputEncoding {
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		return InputEncodingUTF8
+	case bytes.HasPrefix(b, bomUTF16LE):
+		return InputEncodingUTF16LE
+	case bytes.HasPrefix(b, bomUTF16BE):
+		return InputEncodingUTF16BE
+	case utf8.Valid(b):
+		return InputEncodingUTF8
+	default:
+		return InputEncodingLatin1
+	}
+}
+
+// DecodeText returns the UTF-8 text tree-sitter parses for b in encoding
+// enc: b without its byte order mark, transcoded to UTF-8. Node byte
+// offsets of trees returned by ParseBytes and ParseBytesEncoding are
+// offsets into this text.
+func DecodeText(b []byte, enc InputEncoding) ([]byte, error) {
+	switch enc {
+	case InputEncodingUTF8:
+		return bytes.TrimPrefix(b, bomUTF8), nil
+	case InputEncodingUTF16LE:
+		return decodeUTF16(bytes.TrimPrefix(b, bomUTF16LE), binary.LittleEndian)
+	case InputEncodingUTF16BE:
+		return decodeUTF16(bytes.TrimPrefix(b, bomUTF16BE), binary.BigEndian)
+	case InputEncodingLatin1:
+		text := make([]byte, 0, len(b))
+		for _, c := range b {
+			text = utf8.AppendRune(text, rune(c))
+		}
+		return text, nil
+	default:
+		return nil, fmt.Errorf("unknown input encoding %d", enc)
+	}
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) ([]byte, error) {
+	if len(b)%2 != 0 {
+		return nil, errors.New("odd length utf-16 input")
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[i*2:])
+	}
+	text := make([]byte, 0, len(b))
+	for _, r := range utf16.Decode(units) {
+		text = utf8.AppendRune(text, r)
+	}
+	return text, nil
+}
+
+// ParseBytes parses b, reusing the unchanged parts of oldTree like
+// ParseStringWithOldTree. The encoding of b is found with DetectEncoding.
+func (p Parser) ParseBytes(ctx context.Context, oldTree Tree, b []byte, opts ...ParseOptions) (Tree, error) {
+	return p.ParseBytesEncoding(ctx, oldTree, b, DetectEncoding(b), opts...)
+}
+
+// ParseBytesEncoding parses b in encoding enc, reusing the unchanged parts
+// of oldTree like ParseStringWithOldTree.
+//
+// The wasm module does not export ts_parser_parse_string_encoding, so the
+// text is decoded with DecodeText before parsing: node byte offsets count
+// UTF-8 bytes after the byte order mark, whatever the input encoding. For
+// UTF-8 input without a byte order mark they are offsets into b.
+func (p Parser) ParseBytesEncoding(
+	ctx context.Context,
+	oldTree Tree,
+	b []byte,
+	enc InputEncoding,
+	opts ...ParseOptions,
+) (Tree, error) {
+	if err := p.checkParse(oldTree); err != nil {
+		return Tree{}, fmt.Errorf("parsing bytes: %w", err)
+	}
+	text, err := DecodeText(b, enc)
+	if err != nil {
+		return Tree{}, fmt.Errorf("parsing bytes: decoding %s: %w", enc, err)
+	}
+	textPtr, textSize, freeText, err := p.t.allocateBytes(ctx, text)
+	if err != nil {
+		return Tree{}, fmt.Errorf("parsing bytes: %w", err)
+	}
+	defer freeText()
+
+	return p.parse(ctx, oldTree, parseText{ptr: textPtr, size: uint32(textSize)}, opts)
+}
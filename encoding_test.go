@@ -0,0 +1,54 @@
+package treesittergo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want InputEncoding
+	}{
+		{"", InputEncodingUTF8},
+		{"select 1;", InputEncodingUTF8},
+		{"\xef\xbb\xbfselect 1;", InputEncodingUTF8},
+		{"caf\xc3\xa9", InputEncodingUTF8},
+		{"\xff\xfes\x00", InputEncodingUTF16LE},
+		{"\xfe\xff\x00s", InputEncodingUTF16BE},
+		{"caf\xe9", InputEncodingLatin1},
+	} {
+		if got := DetectEncoding([]byte(tt.in)); got != tt.want {
+			t.Errorf("DetectEncoding(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		enc     InputEncoding
+		want    string
+		wantErr bool
+	}{
+		{"select 1;", InputEncodingUTF8, "select 1;", false},
+		{"\xef\xbb\xbfselect", InputEncodingUTF8, "select", false},
+		{"\xff\xfes\x00\xe9\x00", InputEncodingUTF16LE, "sé", false},
+		{"s\x00", InputEncodingUTF16LE, "s", false},
+		{"\xfe\xff\x00s\x00\xe9", InputEncodingUTF16BE, "sé", false},
+		// surrogate pair
+		{"\x3d\xd8\x00\xde", InputEncodingUTF16LE, "\U0001f600", false},
+		{"\xff\xfes", InputEncodingUTF16LE, "", true},
+		{"caf\xe9", InputEncodingLatin1, "café", false},
+		{"x", InputEncoding(42), "", true},
+	} {
+		got, err := DecodeText([]byte(tt.in), tt.enc)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DecodeText(%q, %v) error = %v, want error %v", tt.in, tt.enc, err, tt.wantErr)
+			continue
+		}
+		if !bytes.Equal(got, []byte(tt.want)) {
+			t.Errorf("DecodeText(%q, %v) = %q, want %q", tt.in, tt.enc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// hostModuleName is the name of the module of host functions imported by
+// the embedded module and by grammar side modules.
+const hostModuleName = "treesittergo"
+
+// errAbort is the panic of a side module calling abort, returned by the
+// call that reached it.
+var errAbort = errors.New("wasm module called abort")
+
+// instantiateHost instantiates the host module into r. Its functions are
+// the callbacks of the runtime, and the runtime support tree-sitter gives
+// external scanners besides the C library.
+func instantiateHost(ctx context.Context, r wazero.Runtime) error {
+	_, err := r.NewHostModuleBuilder(hostModuleName).
+		NewFunctionBuilder().
+		WithFunc(inputRead).
+		Export("input_read").
+		NewFunctionBuilder().
+		WithFunc(parserLog).
+		Export("log").
+		NewFunctionBuilder().
+		WithFunc(func(context.Context) { panic(errAbort) }).
+		Export("abort").
+		NewFunctionBuilder().
+		WithFunc(assertFail).
+		Export("__assert_fail").
+		NewFunctionBuilder().
+		WithFunc(func(context.Context, uint32, uint32, uint32) uint32 { return 0 }).
+		Export("__cxa_atexit").
+		NewFunctionBuilder().
+		WithFunc(func(context.Context, uint32) {}).
+		Export("emscripten_notify_memory_growth").
+		Instantiate(ctx)
+	if err != nil {
+		return fmt.Errorf("instantiating host module: %w", err)
+	}
+	return nil
+}
+
+// assertFail implements __assert_fail, called by a failed assert.
+func assertFail(_ context.Context, m api.Module, expr, file, line, fn uint32) {
+	mem := m.Memory()
+	panic(fmt.Errorf("%s:%d: %s: assertion failed: %s",
+		cString(mem, file), line, cString(mem, fn), cString(mem, expr)))
+}
+
+// cString reads the NUL terminated string at ptr.
+func cString(mem api.Memory, ptr uint32) string {
+	var s []byte
+	for {
+		c, ok := mem.ReadByte(ptr)
+		if !ok || c == 0 {
+			return string(s)
+		}
+		s = append(s, c)
+		ptr++
+	}
+}
@@ -0,0 +1,174 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// inputChunkSize is the size of the chunks read by ReaderAtInput and
+// ParseReader.
+const inputChunkSize = 64 * 1024
+
+// Input returns the text of a document starting at byte offset. It may
+// return less than the rest of the document, and returns an empty chunk
+// at the end of it. It mirrors the read callback of tree-sitter's TSInput.
+type Input func(offset uint32) ([]byte, error)
+
+// ReaderAtInput returns an Input reading r in chunks.
+func ReaderAtInput(r io.ReaderAt) Input {
+	buf := make([]byte, inputChunkSize)
+	return func(offset uint32) ([]byte, error) {
+		n, err := r.ReadAt(buf, int64(offset))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// ChunksInput returns an Input reading a document split in chunks, such as
+// the leaves of a rope.
+func ChunksInput(chunks [][]byte) Input {
+	starts := make([]uint32, len(chunks))
+	var offset uint32
+	for i, c := range chunks {
+		starts[i] = offset
+		offset += uint32(len(c))
+	}
+	return func(offset uint32) ([]byte, error) {
+		i := sort.Search(len(starts), func(i int) bool { return starts[i] > offset }) - 1
+		for ; i >= 0 && i < len(chunks); i++ {
+			if rel := offset - starts[i]; int(rel) < len(chunks[i]) {
+				return chunks[i][rel:], nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// ParseInput parses the document read from input, reusing the unchanged
+// parts of oldTree like ParseStringWithOldTree.
+//
+// The parser calls back into Go to read each chunk into a guest buffer of
+// 64 KiB, so the document never needs to fit in guest memory. A parse of
+// an input stopped with ErrParseTimeout or ErrParseCancelled is not
+// resumed, as its text cannot be compared with the next one.
+func (p Parser) ParseInput(ctx context.Context, oldTree Tree, input Input, opts ...ParseOptions) (Tree, error) {
+	return p.parseInput(ctx, oldTree, input, 0, opts)
+}
+
+// ParseReaderAt parses the size bytes of r, like ParseInput.
+func (p Parser) ParseReaderAt(ctx context.Context, oldTree Tree, r io.ReaderAt, size int64, opts ...ParseOptions) (Tree, error) {
+	if size < 0 {
+		return Tree{}, errors.New("parsing input: negative size")
+	}
+	if size > int64(^uint32(0)) {
+		return Tree{}, errors.New("parsing input: input larger than 4 GiB")
+	}
+	return p.parseInput(ctx, oldTree, ReaderAtInput(io.NewSectionReader(r, 0, size)), uint32(size), opts)
+}
+
+// ParseChunks parses a document split in chunks, like ParseInput with
+// ChunksInput.
+func (p Parser) ParseChunks(ctx context.Context, oldTree Tree, chunks [][]byte, opts ...ParseOptions) (Tree, error) {
+	var size int64
+	for _, c := range chunks {
+		size += int64(len(c))
+	}
+	if size > int64(^uint32(0)) {
+		return Tree{}, errors.New("parsing input: input larger than 4 GiB")
+	}
+	return p.parseInput(ctx, oldTree, ChunksInput(chunks), uint32(size), opts)
+}
+
+// parseInput parses the document read from input. size is the length of
+// the document, reported to ParseOptions.Progress, or 0 if unknown.
+func (p Parser) parseInput(ctx context.Context, oldTree Tree, input Input, size uint32, opts []ParseOptions) (Tree, error) {
+	if err := p.checkParse(oldTree); err != nil {
+		return Tree{}, fmt.Errorf("parsing input: %w", err)
+	}
+
+	// TSInput 12 bytes, followed by the chunk buffer
+	ptr, err := p.t.allocate(ctx, 12+inputChunkSize)
+	if err != nil {
+		return Tree{}, fmt.Errorf("parsing input: %w", err)
+	}
+	defer p.t.free.Call(context.WithoutCancel(ctx), ptr)
+	if _, err := p.t.inputInit.Call(ctx, ptr, ptr+12); err != nil {
+		return Tree{}, fmt.Errorf("parsing input: %w", err)
+	}
+
+	return p.parse(ctx, oldTree, parseText{
+		ptr:   ptr,
+		size:  size,
+		input: &inputReader{input: input},
+	}, opts)
+}
+
+// ParseReader parses the document read from r until io.EOF, like
+// ParseInput. The parser may go back in the text, so what was read from r
+// is kept in Go memory until the parse returns.
+func (p Parser) ParseReader(ctx context.Context, oldTree Tree, r io.Reader, opts ...ParseOptions) (Tree, error) {
+	var text []byte
+	var eof bool
+	return p.parseInput(ctx, oldTree, func(offset uint32) ([]byte, error) {
+		for !eof && uint64(offset) >= uint64(len(text)) {
+			if len(text) == cap(text) {
+				text = slices.Grow(text, inputChunkSize)
+			}
+			n, err := r.Read(text[len(text):cap(text)])
+			text = text[:len(text)+n]
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if uint64(offset) >= uint64(len(text)) {
+			return nil, nil
+		}
+		return text[offset:], nil
+	}, 0, opts)
+}
+
+// inputReaderKey is the context key of the inputReader of a parse.
+type inputReaderKey struct{}
+
+// inputReader reads the Input of a parse for the input_read host function,
+// which finds it in the context of the parse.
+type inputReader struct {
+	input Input
+	// err is the first error of input, which ends the document.
+	err error
+	// end is the size of the document once input reached its end.
+	end uint32
+}
+
+// inputRead implements the read callback of TSInput: it writes the chunk
+// of the document at offset to the buffer at payload, and its size to
+// bytesRead.
+func inputRead(ctx context.Context, m api.Module, payload, offset, _, bytesRead uint32) uint32 {
+	var n uint32
+	if r, ok := ctx.Value(inputReaderKey{}).(*inputReader); ok && r.err == nil {
+		chunk, err := r.input(offset)
+		n = uint32(min(len(chunk), inputChunkSize))
+		switch {
+		case err != nil:
+			r.err = fmt.Errorf("reading input at %d: %w", offset, err)
+			n = 0
+		case !m.Memory().Write(payload, chunk[:n]):
+			r.err = errors.New("writing input chunk")
+			n = 0
+		case n == 0:
+			r.end = max(r.end, offset)
+		}
+	}
+	m.Memory().WriteUint32Le(bytesRead, n)
+	return payload
+}
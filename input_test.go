@@ -0,0 +1,104 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseInput(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	// several times the chunk buffer, so the parser reads it back and forth
+	src := strings.Repeat("select a, b from t where c = 1;\n", 3000)
+	want, err := p.ParseString(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer want.Close(ctx)
+
+	var chunks [][]byte
+	for s := src; len(s) > 0; s = s[min(len(s), 7):] {
+		chunks = append(chunks, []byte(s[:min(len(s), 7)]))
+	}
+	for _, tt := range []struct {
+		name  string
+		parse func() (Tree, error)
+	}{
+		{"ParseInput", func() (Tree, error) { return p.ParseInput(ctx, Tree{}, ChunksInput(chunks)) }},
+		{"ParseChunks", func() (Tree, error) { return p.ParseChunks(ctx, Tree{}, chunks) }},
+		{"ParseReaderAt", func() (Tree, error) {
+			return p.ParseReaderAt(ctx, Tree{}, strings.NewReader(src), int64(len(src)))
+		}},
+		{"ParseReader", func() (Tree, error) {
+			return p.ParseReader(ctx, Tree{}, iotest.HalfReader(strings.NewReader(src)))
+		}},
+	} {
+		tree, err := tt.parse()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := treeString(t, tree); got != treeString(t, want) {
+			t.Errorf("%s: tree differs from ParseString", tt.name)
+		}
+		tree.Close(ctx)
+	}
+}
+
+func TestParseInputGuestMemory(t *testing.T) {
+	ctx := context.Background()
+	ts, p := newTestParser(t)
+
+	// a comment parses to a small tree whatever its length
+	const size = 16 << 20
+	input := func(offset uint32) ([]byte, error) {
+		chunk := []byte(strings.Repeat("x", 4096))
+		switch {
+		case offset == 0:
+			return []byte("-- "), nil
+		case offset >= size:
+			return []byte("\nselect 1;")[min(offset-size, 10):], nil
+		}
+		return chunk[:min(len(chunk), int(size-offset))], nil
+	}
+	before := ts.m.Memory().Size()
+	tree, err := p.ParseInput(ctx, Tree{}, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	if got := ts.m.Memory().Size() - before; got >= size/4 {
+		t.Errorf("parsing a %d byte input grew guest memory by %d bytes", size, got)
+	}
+	if got := treeString(t, tree); !strings.Contains(got, "(comment)") {
+		t.Errorf("parsed %s", got)
+	}
+}
+
+func TestParseInputError(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	errRead := errors.New("read failed")
+	input := func(offset uint32) ([]byte, error) {
+		if offset > 0 {
+			return nil, errRead
+		}
+		return []byte("select a "), nil
+	}
+	if _, err := p.ParseInput(ctx, Tree{}, input); !errors.Is(err, errRead) {
+		t.Fatalf("ParseInput = %v, want %v", err, errRead)
+	}
+
+	tree, err := p.ParseString(ctx, "select 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	if got := treeString(t, tree); strings.Contains(got, "ERROR") {
+		t.Errorf("parse after a failed input parse: %s", got)
+	}
+}
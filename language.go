@@ -25,6 +25,12 @@ func NewLanguage(l uint64, t Treesitter) Language {
 }
 
 func (l Language) Name(ctx context.Context) (string, error) {
+	if err := l.t.checkOpen(); err != nil {
+		return "", fmt.Errorf("getting language name: %w", err)
+	}
+	if l.l == 0 {
+		return "", fmt.Errorf("getting language name: %w", ErrNoLanguage)
+	}
 	langNamePtr, err := l.t.languageName.Call(context.Background(), l.l)
 	if err != nil {
 		return "", fmt.Errorf("getting language name: %w", err)
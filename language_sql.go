@@ -3,9 +3,32 @@ package treesittergo
 import (
 	"context"
 	"fmt"
+	"regexp"
 )
 
+// sqlStatementStart matches content starting, after blank lines and
+// comments, with a statement: a keyword that opens a SQL statement and a
+// body ended by ";".
+var sqlStatementStart = regexp.MustCompile(`(?is)^(?:\s+|--[^\n]*\n?|/\*.*?\*/)*` +
+	`(?:select|with|insert|update|delete|merge|create|alter|drop|truncate|grant|revoke|begin|set)\s[^;]*\S\s*;`)
+
+func init() {
+	RegisterLanguage(LanguageSpec{
+		Name:         "sql",
+		Aliases:      []string{"postgresql", "postgres", "pgsql", "mysql", "sqlite"},
+		Extensions:   []string{".sql", ".pgsql", ".psql", ".ddl", ".dml"},
+		Interpreters: []string{"psql", "mysql", "sqlite3"},
+		Detect:       sqlStatementStart.Match,
+		Load: func(ctx context.Context, t Treesitter) (Language, error) {
+			return t.LanguageSQL(ctx)
+		},
+	})
+}
+
 func (t Treesitter) LanguageSQL(ctx context.Context) (Language, error) {
+	if err := t.checkOpen(); err != nil {
+		return Language{}, fmt.Errorf("initiating sql language: %w", err)
+	}
 	sqlLangPtr, err := t.languageSQL.Call(ctx)
 	if err != nil {
 		return Language{}, fmt.Errorf("initiating sql language: %w", err)
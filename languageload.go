@@ -0,0 +1,460 @@
+package treesittergo
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// stdlibSymbols are the C library functions of the module that external
+// scanners may import, the same as tree-sitter offers them.
+var stdlibSymbols = []string{
+	"calloc", "free", "iswalnum", "iswalpha", "iswblank", "iswdigit",
+	"iswlower", "iswspace", "iswupper", "iswxdigit", "malloc", "memchr",
+	"memcmp", "memcpy", "memmove", "memset", "realloc", "strcmp", "strlen",
+	"strncat", "strncmp", "strncpy", "towlower", "towupper",
+}
+
+// hostSymbols are the functions of the host module that external scanners
+// may import.
+var hostSymbols = []string{"abort", "__assert_fail", "__cxa_atexit", "emscripten_notify_memory_growth"}
+
+// mainModuleName is the name side modules import the module of a
+// Treesitter by, through an import resolver.
+const mainModuleName = "treesitter"
+
+var wasmHeader = []byte("\x00asm\x01\x00\x00\x00")
+
+// linked are the grammar side modules LoadLanguage linked into the module
+// of a Treesitter, closed with it.
+type linked struct {
+	r wazero.Runtime
+	// growTable grows the function table of the module. It is created by
+	// the first LoadLanguage.
+	growTable api.Function
+	languages map[string]uint64
+	modules   []api.Module
+	compiled  []wazero.CompiledModule
+}
+
+func (l *linked) close(ctx context.Context) {
+	for _, m := range slices.Backward(l.modules) {
+		m.Close(ctx)
+	}
+	for _, c := range l.compiled {
+		c.Close(ctx)
+	}
+}
+
+// LoadLanguage links the grammar name from wasm, a side module built with
+// `tree-sitter build --wasm`, into t and returns its language, for use
+// with Parser.SetLanguage and NewQuery. Loading name again returns the
+// language linked first.
+//
+// The data of the grammar is placed in guest memory and its functions in
+// the function table of t. Its external scanner may import the C library
+// functions tree-sitter offers scanners. Both stay in t until it is
+// closed.
+func (t Treesitter) LoadLanguage(ctx context.Context, name string, wasm []byte) (Language, error) {
+	if err := t.checkOpen(); err != nil {
+		return Language{}, fmt.Errorf("loading language %s: %w", name, err)
+	}
+	if l, ok := t.linked.languages[name]; ok {
+		return NewLanguage(l, t), nil
+	}
+	l, err := t.link(ctx, name, wasm)
+	if err != nil {
+		return Language{}, fmt.Errorf("loading language %s: %w", name, err)
+	}
+	t.linked.languages[name] = l
+	return NewLanguage(l, t), nil
+}
+
+// link instantiates the side module wasm against t and returns the address
+// of its language.
+func (t Treesitter) link(ctx context.Context, name string, wasm []byte) (uint64, error) {
+	side, err := parseSideModule(wasm)
+	if err != nil {
+		return 0, err
+	}
+	if t.linked.growTable == nil {
+		if err := t.instantiateTableGrow(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	compiled, err := t.compileLinked(ctx, side.wasm)
+	if err != nil {
+		return 0, fmt.Errorf("compiling module: %w", err)
+	}
+	languageFunc := "tree_sitter_" + name
+	if _, ok := compiled.ExportedFunctions()[languageFunc]; !ok {
+		return 0, fmt.Errorf("module does not export %s", languageFunc)
+	}
+
+	// The memory is never freed: the language points into it until t is
+	// closed.
+	align := uint64(1) << side.memoryAlign
+	ptr, err := t.allocate(ctx, uint64(side.memorySize)+align-1)
+	if err != nil {
+		return 0, fmt.Errorf("allocating memory: %w", err)
+	}
+	memoryBase := (ptr + align - 1) &^ (align - 1)
+	// the data segment only covers initialized data
+	if !t.m.Memory().Write(uint32(memoryBase), make([]byte, side.memorySize)) {
+		return 0, fmt.Errorf("clearing memory: %d bytes at %d out of range", side.memorySize, memoryBase)
+	}
+
+	res, err := t.linked.growTable.Call(ctx, uint64(side.tableSize))
+	if err != nil {
+		return 0, fmt.Errorf("growing function table: %w", err)
+	}
+	tableBase := uint32(res[0])
+	if int32(tableBase) == -1 {
+		return 0, fmt.Errorf("growing function table by %d", side.tableSize)
+	}
+
+	envCompiled, err := t.compileLinked(ctx, envModule(uint32(memoryBase), tableBase))
+	if err != nil {
+		return 0, fmt.Errorf("compiling env module: %w", err)
+	}
+	env, err := t.instantiateLinked(ctx, envCompiled, nil)
+	if err != nil {
+		return 0, fmt.Errorf("instantiating env module: %w", err)
+	}
+	mod, err := t.instantiateLinked(ctx, compiled, env)
+	if err != nil {
+		return 0, fmt.Errorf("instantiating module: %w", err)
+	}
+
+	for _, init := range []string{"__wasm_apply_data_relocs", "__wasm_call_ctors", "_initialize"} {
+		if fn := mod.ExportedFunction(init); fn != nil {
+			if _, err := fn.Call(ctx); err != nil {
+				return 0, fmt.Errorf("calling %s: %w", init, err)
+			}
+		}
+	}
+
+	res, err = mod.ExportedFunction(languageFunc).Call(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("calling %s: %w", languageFunc, err)
+	}
+	if res[0] == 0 {
+		return 0, fmt.Errorf("%s returned NULL", languageFunc)
+	}
+	return res[0], nil
+}
+
+// compileLinked compiles wasm and records it to be closed with t.
+func (t Treesitter) compileLinked(ctx context.Context, wasm []byte) (wazero.CompiledModule, error) {
+	compiled, err := t.linked.r.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, err
+	}
+	t.linked.compiled = append(t.linked.compiled, compiled)
+	return compiled, nil
+}
+
+// instantiateLinked instantiates compiled with imports of mainModuleName
+// resolved to the module of t and imports of env to env, and records it to
+// be closed with t.
+func (t Treesitter) instantiateLinked(ctx context.Context, compiled wazero.CompiledModule, env api.Module) (api.Module, error) {
+	ctx = experimental.WithImportResolver(ctx, func(name string) api.Module {
+		switch {
+		case name == mainModuleName:
+			return t.m
+		case name == "env" && env != nil:
+			return env
+		}
+		return nil
+	})
+	cfg := wazero.NewModuleConfig().WithName("").WithStderr(t.stderr)
+	mod, err := t.linked.r.InstantiateModule(ctx, compiled, cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.linked.modules = append(t.linked.modules, mod)
+	return mod, nil
+}
+
+// instantiateTableGrow instantiates a module exporting a function that
+// grows the function table of t, which wazero cannot do from the host.
+func (t Treesitter) instantiateTableGrow(ctx context.Context) error {
+	var imports []byte
+	imports = binary.AppendUvarint(imports, 1)
+	imports = appendWasmName(imports, mainModuleName)
+	imports = appendWasmName(imports, "__indirect_function_table")
+	imports = append(imports, byte(api.ExternTypeTable), 0x70, 0, 0)
+
+	// (func (param i32) (result i32)
+	//   (table.grow 0 (ref.null func) (local.get 0)))
+	body := []byte{0, 0xd0, 0x70, 0x20, 0, 0xfc, 0x0f, 0, 0x0b}
+
+	wasm := slices.Clone(wasmHeader)
+	wasm = appendWasmSection(wasm, 1, []byte{1, 0x60, 1, api.ValueTypeI32, 1, api.ValueTypeI32})
+	wasm = appendWasmSection(wasm, 2, imports)
+	wasm = appendWasmSection(wasm, 3, []byte{1, 0})
+	wasm = appendWasmSection(wasm, 7, appendWasmName([]byte{1}, "grow"), 0, 0)
+	wasm = appendWasmSection(wasm, 10, append([]byte{1, byte(len(body))}, body...))
+
+	compiled, err := t.compileLinked(ctx, wasm)
+	if err != nil {
+		return fmt.Errorf("compiling table module: %w", err)
+	}
+	mod, err := t.instantiateLinked(ctx, compiled, nil)
+	if err != nil {
+		return fmt.Errorf("instantiating table module: %w", err)
+	}
+	t.linked.growTable = mod.ExportedFunction("grow")
+	return nil
+}
+
+// sideModule is a grammar side module, following the WebAssembly dynamic
+// linking conventions emscripten uses.
+type sideModule struct {
+	// memorySize is the size of the data of the module, aligned to
+	// 1<<memoryAlign bytes.
+	memorySize, memoryAlign uint32
+	// tableSize is the number of functions the module adds to the table.
+	tableSize uint32
+	// wasm is the module with its imports rewritten by linkImports.
+	wasm []byte
+}
+
+var (
+	errTruncated     = errors.New("truncated module")
+	errNotSideModule = errors.New("not a side module: no dylink.0 section")
+)
+
+// parseSideModule reads the dynamic linking information of the side module
+// wasm and links its imports.
+func parseSideModule(wasm []byte) (sideModule, error) {
+	if !bytes.HasPrefix(wasm, wasmHeader) {
+		return sideModule{}, errors.New("not a wasm module")
+	}
+	side := sideModule{wasm: slices.Clone(wasmHeader)}
+	dylink := false
+
+	r := &wasmReader{b: wasm[len(wasmHeader):]}
+	for len(r.b) > 0 && r.err == nil {
+		id := r.byte()
+		payload := r.bytes(r.u32())
+		s := &wasmReader{b: payload}
+		switch id {
+		case 0:
+			if s.name() == "dylink.0" {
+				dylink = true
+				for len(s.b) > 0 && s.err == nil {
+					kind := s.byte()
+					sub := &wasmReader{b: s.bytes(s.u32())}
+					if kind == 1 { // WASM_DYLINK_MEM_INFO
+						side.memorySize = sub.u32()
+						side.memoryAlign = sub.u32()
+						side.tableSize = sub.u32()
+						sub.u32() // table alignment
+					}
+					s.err = cmp.Or(s.err, sub.err)
+				}
+			}
+		case 2:
+			// dylink.0 comes before any other section of a side module
+			if !dylink {
+				return sideModule{}, errNotSideModule
+			}
+			var err error
+			payload, err = linkImports(s)
+			if err != nil {
+				return sideModule{}, err
+			}
+		}
+		if s.err != nil {
+			return sideModule{}, s.err
+		}
+		side.wasm = appendWasmSection(side.wasm, id, payload)
+	}
+	if r.err != nil {
+		return sideModule{}, r.err
+	}
+	if !dylink {
+		return sideModule{}, errNotSideModule
+	}
+	if side.memoryAlign > 16 {
+		return sideModule{}, fmt.Errorf("invalid memory alignment 2^%d", side.memoryAlign)
+	}
+	return side, nil
+}
+
+// linkImports re-encodes the import section s with every env import but
+// the memory and table bases moved to the module providing it, and the
+// limits of the memory and table imports removed, as the module of a
+// Treesitter grows both on its own.
+//
+// Functions are imported from their module rather than re-exported by the
+// env module, as wazero resolves a function re-exported by another module
+// to the wrong index.
+func linkImports(s *wasmReader) ([]byte, error) {
+	n := s.u32()
+	out := binary.AppendUvarint(nil, uint64(n))
+	for ; n > 0 && s.err == nil; n-- {
+		module, name, kind := s.name(), s.name(), s.byte()
+		from, err := importModule(module, name, kind)
+		if err != nil {
+			return nil, err
+		}
+		out = appendWasmName(out, from)
+		out = appendWasmName(out, name)
+		out = append(out, kind)
+		switch kind {
+		case api.ExternTypeFunc:
+			out = binary.AppendUvarint(out, uint64(s.u32()))
+		case api.ExternTypeTable:
+			out = append(out, s.byte())
+			fallthrough
+		case api.ExternTypeMemory:
+			flags := s.byte()
+			if flags&^1 != 0 {
+				return nil, fmt.Errorf("import %s.%s: unsupported limits %#x", module, name, flags)
+			}
+			s.u32()
+			if flags&1 != 0 {
+				s.u32()
+			}
+			out = append(out, 0, 0)
+		case api.ExternTypeGlobal:
+			out = append(out, s.bytes(2)...)
+		}
+	}
+	return out, s.err
+}
+
+// importModule returns the module providing the import name of module.
+func importModule(module, name string, kind api.ExternType) (string, error) {
+	if module == wasi_snapshot_preview1.ModuleName {
+		return module, nil
+	}
+	if module == "env" {
+		switch kind {
+		case api.ExternTypeFunc:
+			if slices.Contains(stdlibSymbols, name) {
+				return mainModuleName, nil
+			}
+			if slices.Contains(hostSymbols, name) {
+				return hostModuleName, nil
+			}
+		case api.ExternTypeMemory:
+			if name == "memory" {
+				return mainModuleName, nil
+			}
+		case api.ExternTypeTable:
+			if name == "__indirect_function_table" {
+				return mainModuleName, nil
+			}
+		case api.ExternTypeGlobal:
+			switch name {
+			case "__stack_pointer":
+				return mainModuleName, nil
+			case "__memory_base", "__table_base":
+				return module, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unsupported import %s.%s", module, name)
+}
+
+// envModule returns a module exporting the __memory_base and __table_base
+// globals, placing a side module at memoryBase and tableBase.
+func envModule(memoryBase, tableBase uint32) []byte {
+	var globals, exports []byte
+	for i, g := range []struct {
+		name string
+		base uint32
+	}{{"__memory_base", memoryBase}, {"__table_base", tableBase}} {
+		globals = append(globals, api.ValueTypeI32, 0, 0x41)
+		globals = appendSLEB(globals, int32(g.base))
+		globals = append(globals, 0x0b)
+		exports = appendWasmName(exports, g.name)
+		exports = append(exports, api.ExternTypeGlobal, byte(i))
+	}
+
+	wasm := slices.Clone(wasmHeader)
+	wasm = appendWasmSection(wasm, 6, []byte{2}, globals...)
+	wasm = appendWasmSection(wasm, 7, []byte{2}, exports...)
+	return wasm
+}
+
+// appendWasmSection appends the section id holding the concatenation of
+// payload and rest to b.
+func appendWasmSection(b []byte, id byte, payload []byte, rest ...byte) []byte {
+	b = append(b, id)
+	b = binary.AppendUvarint(b, uint64(len(payload)+len(rest)))
+	b = append(b, payload...)
+	return append(b, rest...)
+}
+
+func appendWasmName(b []byte, name string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(name)))
+	return append(b, name...)
+}
+
+// appendSLEB appends v in signed LEB128.
+func appendSLEB(b []byte, v int32) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// wasmReader reads a wasm binary. Reading past its end sets err and
+// returns zero values.
+type wasmReader struct {
+	b   []byte
+	err error
+}
+
+func (r *wasmReader) byte() byte {
+	if len(r.b) == 0 {
+		r.err = cmp.Or(r.err, errTruncated)
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+// u32 reads an unsigned LEB128 number, the encoding of binary.Uvarint.
+func (r *wasmReader) u32() uint32 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 || v > 1<<32-1 {
+		r.err = cmp.Or(r.err, errTruncated)
+		r.b = nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return uint32(v)
+}
+
+func (r *wasmReader) bytes(n uint32) []byte {
+	if uint64(n) > uint64(len(r.b)) {
+		r.err = cmp.Or(r.err, errTruncated)
+		r.b = nil
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *wasmReader) name() string { return string(r.bytes(r.u32())) }
@@ -0,0 +1,152 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// dockerfileWasm is a grammar side module with an external scanner, built
+// by wasm/grammar.sh.
+func dockerfileWasm(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile("testdata/tree-sitter-dockerfile.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLoadLanguage(t *testing.T) {
+	ctx := context.Background()
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close(ctx)
+
+	lang, err := ts.LoadLanguage(ctx, "dockerfile", dockerfileWasm(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ts.NewParser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(ctx)
+	if err := p.SetLanguage(ctx, lang); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		src, want string
+	}{
+		{
+			"FROM alpine:3.20\nRUN echo hi\n",
+			"(source_file (from_instruction (image_spec name: (image_name) tag: (image_tag))) " +
+				"(run_instruction (shell_command (shell_fragment))))",
+		},
+		// heredocs are lexed by the external scanner, which allocates
+		{
+			"RUN <<EOF\necho hi\nEOF\n",
+			"(source_file (run_instruction (shell_command (shell_fragment (heredoc_marker))) " +
+				"(heredoc_block (heredoc_line) (heredoc_end))))",
+		},
+	} {
+		tree, err := p.ParseString(ctx, tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := treeString(t, tree); got != tt.want {
+			t.Errorf("parsing %q:\ngot  %s\nwant %s", tt.src, got, tt.want)
+		}
+		tree.Close(ctx)
+	}
+
+	q, err := ts.NewQuery(ctx, "(image_name) @image", lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(ctx)
+	if _, err := ts.NewQuery(ctx, "(select_statement)", lang); err == nil {
+		t.Error("NewQuery with a node type of another language succeeded")
+	}
+
+	again, err := ts.LoadLanguage(ctx, "dockerfile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != lang {
+		t.Error("loading the language again linked it again")
+	}
+}
+
+func TestLoadLanguageErrors(t *testing.T) {
+	ctx := context.Background()
+	wasm := dockerfileWasm(t)
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		wasm []byte
+		want string
+	}{
+		{"dockerfile", []byte("FROM alpine"), "not a wasm module"},
+		{"dockerfile", wasm[:len(wasm)/2], "truncated module"},
+		{"sql", tsWasm, "not a side module"},
+		{"go", wasm, "module does not export tree_sitter_go"},
+	} {
+		_, err := ts.LoadLanguage(ctx, tt.name, tt.wasm)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadLanguage(%s) = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+
+	ts.Close(ctx)
+	if _, err := ts.LoadLanguage(ctx, "dockerfile", wasm); !errors.Is(err, ErrClosed) {
+		t.Errorf("LoadLanguage after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestLoadLanguagePool(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPool(t, 2)
+	wasm := dockerfileWasm(t)
+
+	// each instance links its own copy of the grammar
+	var instances []Treesitter
+	for range 2 {
+		ts, err := p.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, ts)
+		lang, err := ts.LoadLanguage(ctx, "dockerfile", wasm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser, err := ts.NewParser(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := parser.SetLanguage(ctx, lang); err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(ctx, "FROM scratch\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := treeString(t, tree); !strings.HasPrefix(got, "(source_file (from_instruction") {
+			t.Errorf("parsed %s", got)
+		}
+		tree.Close(ctx)
+		parser.Close(ctx)
+	}
+	for _, ts := range instances {
+		p.Release(ts)
+	}
+}
@@ -0,0 +1,405 @@
+package treesittergo
+
+import (
+	"errors"
+	"strings"
+)
+
+// luaPattern is a compiled Lua pattern, the pattern syntax of Lua's
+// string.find, used by queries written for Neovim. It is matched on bytes
+// with the character classes of the C locale.
+type luaPattern struct {
+	pattern string
+	anchor  bool
+}
+
+const (
+	luaMaxCaptures = 32
+	// luaMaxDepth bounds the recursion of a match, like MAXCCALLS in Lua.
+	luaMaxDepth = 200
+
+	luaCapUnfinished = -1
+	luaCapPosition   = -2
+)
+
+// compileLuaPattern checks the syntax of pattern.
+func compileLuaPattern(pattern string) (luaPattern, error) {
+	lp := luaPattern{pattern: pattern}
+	if strings.HasPrefix(pattern, "^") {
+		lp.pattern, lp.anchor = pattern[1:], true
+	}
+
+	p := lp.pattern
+	// captures holds whether each capture opened so far is closed.
+	var captures []bool
+	for i := 0; i < len(p); {
+		switch p[i] {
+		case '(':
+			if len(captures) == luaMaxCaptures {
+				return luaPattern{}, errors.New("too many captures")
+			}
+			if i+1 < len(p) && p[i+1] == ')' {
+				captures = append(captures, true)
+				i += 2
+				continue
+			}
+			captures = append(captures, false)
+			i++
+			continue
+		case ')':
+			l := len(captures) - 1
+			for l >= 0 && captures[l] {
+				l--
+			}
+			if l < 0 {
+				return luaPattern{}, errors.New("invalid pattern capture")
+			}
+			captures[l] = true
+			i++
+			continue
+		case '%':
+			if i+1 == len(p) {
+				return luaPattern{}, errors.New("malformed pattern (ends with '%')")
+			}
+			switch c := p[i+1]; {
+			case c == 'b':
+				if i+3 >= len(p) {
+					return luaPattern{}, errors.New("malformed pattern (missing arguments to '%b')")
+				}
+				i += 4
+				continue
+			case c == 'f':
+				i += 2
+				if i == len(p) || p[i] != '[' {
+					return luaPattern{}, errors.New("missing '[' after '%f' in pattern")
+				}
+			case c >= '0' && c <= '9':
+				l := int(c - '1')
+				if l < 0 || l >= len(captures) || !captures[l] {
+					return luaPattern{}, errors.New("invalid capture index %" + string(c))
+				}
+				i += 2
+				continue
+			}
+		}
+		end, ok := luaClassEnd(p, i)
+		if !ok {
+			return luaPattern{}, errors.New("malformed pattern (missing ']')")
+		}
+		i = end
+	}
+	for _, closed := range captures {
+		if !closed {
+			return luaPattern{}, errors.New("unfinished capture")
+		}
+	}
+	return lp, nil
+}
+
+// MatchString reports whether the pattern matches anywhere in s.
+func (lp luaPattern) MatchString(s string) bool {
+	for init := 0; init <= len(s); init++ {
+		ms := luaMatchState{src: s, pat: lp.pattern}
+		if ms.match(init, 0) != -1 {
+			return true
+		}
+		if lp.anchor {
+			break
+		}
+	}
+	return false
+}
+
+// luaClassEnd returns the end of the single character class starting at i
+// in p, and false if a set is not closed.
+func luaClassEnd(p string, i int) (int, bool) {
+	c := p[i]
+	i++
+	switch c {
+	case '%':
+		return min(i+1, len(p)), true
+	case '[':
+		if i < len(p) && p[i] == '^' {
+			i++
+		}
+		// the first character of a set is never its end, so "[]]" is a set
+		for {
+			if i >= len(p) {
+				return len(p), false
+			}
+			c := p[i]
+			i++
+			if c == '%' && i < len(p) {
+				i++
+			}
+			if i >= len(p) {
+				return len(p), false
+			}
+			if p[i] == ']' {
+				return i + 1, true
+			}
+		}
+	default:
+		return i, true
+	}
+}
+
+// luaMatchState is the state of a match, ported from Lua's lstrlib.c.
+// Indexes into src and pat are -1 when matching fails.
+type luaMatchState struct {
+	src, pat string
+	depth    int
+	level    int
+	capture  [luaMaxCaptures]struct{ init, len int }
+}
+
+func (ms *luaMatchState) match(s, p int) int {
+	ms.depth++
+	defer func() { ms.depth-- }()
+	if ms.depth > luaMaxDepth {
+		return -1
+	}
+
+	for p < len(ms.pat) {
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, luaCapPosition)
+			}
+			return ms.startCapture(s, p+1, luaCapUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			switch c := ms.pat[p+1]; {
+			case c == 'b':
+				if s = ms.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case c == 'f':
+				p += 2
+				ep, _ := luaClassEnd(ms.pat, p)
+				var prev, cur byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if ms.matchBracketClass(prev, p, ep-1) || !ms.matchBracketClass(cur, p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case c >= '0' && c <= '9':
+				if s = ms.matchCapture(s, int(c-'1')); s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		ep, _ := luaClassEnd(ms.pat, p)
+		var epc byte
+		if ep < len(ms.pat) {
+			epc = ms.pat[ep]
+		}
+		if !ms.singleMatch(s, p, ep) {
+			if epc == '*' || epc == '?' || epc == '-' {
+				p = ep + 1
+				continue
+			}
+			return -1
+		}
+		switch epc {
+		case '?':
+			if r := ms.match(s+1, ep+1); r != -1 {
+				return r
+			}
+			p = ep + 1
+		case '+':
+			return ms.maxExpand(s+1, p, ep)
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		default:
+			s, p = s+1, ep
+		}
+	}
+	return s
+}
+
+func (ms *luaMatchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return luaMatchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+// matchBracketClass matches c against the set between p, at its '[', and
+// ec, at its ']'.
+func (ms *luaMatchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if luaMatchClass(c, ms.pat[p]) {
+				return sig
+			}
+		case ms.pat[p+1] == '-' && p+2 < ec:
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *luaMatchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if r := ms.match(s+i, ep+1); r != -1 {
+			return r
+		}
+	}
+	return -1
+}
+
+func (ms *luaMatchState) minExpand(s, p, ep int) int {
+	for {
+		if r := ms.match(s, ep+1); r != -1 {
+			return r
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *luaMatchState) startCapture(s, p, what int) int {
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	r := ms.match(s, p)
+	if r == -1 {
+		ms.level--
+	}
+	return r
+}
+
+func (ms *luaMatchState) endCapture(s, p int) int {
+	l := ms.level - 1
+	for l >= 0 && ms.capture[l].len != luaCapUnfinished {
+		l--
+	}
+	if l < 0 {
+		return -1
+	}
+	ms.capture[l].len = s - ms.capture[l].init
+	r := ms.match(s, p)
+	if r == -1 {
+		ms.capture[l].len = luaCapUnfinished
+	}
+	return r
+}
+
+func (ms *luaMatchState) matchBalance(s, p int) int {
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case e:
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		case b:
+			cont++
+		}
+	}
+	return -1
+}
+
+func (ms *luaMatchState) matchCapture(s, l int) int {
+	if l < 0 || l >= ms.level || ms.capture[l].len < 0 {
+		return -1
+	}
+	capture := ms.src[ms.capture[l].init:][:ms.capture[l].len]
+	if strings.HasPrefix(ms.src[s:], capture) {
+		return s + len(capture)
+	}
+	return -1
+}
+
+// luaMatchClass matches c against the class of a "%" escape, such as 'd'
+// for "%d". Upper case classes are complements, and other characters match
+// themselves.
+func luaMatchClass(c, class byte) bool {
+	var res bool
+	switch class | 0x20 {
+	case 'a':
+		res = isLower(c) || isUpper(c)
+	case 'c':
+		res = c < 0x20 || c == 0x7f
+	case 'd':
+		res = c >= '0' && c <= '9'
+	case 'g':
+		res = c > 0x20 && c < 0x7f
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = c > 0x20 && c < 0x7f && !isAlnum(c)
+	case 's':
+		res = c == ' ' || (c >= '\t' && c <= '\r')
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isAlnum(c)
+	case 'x':
+		res = (c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'f')
+	default:
+		return class == c
+	}
+	if isUpper(class) {
+		return !res
+	}
+	return res
+}
+
+func isLower(c byte) bool { return c >= 'a' && c <= 'z' }
+func isUpper(c byte) bool { return c >= 'A' && c <= 'Z' }
+func isAlnum(c byte) bool { return isLower(c) || isUpper(c) || (c >= '0' && c <= '9') }
@@ -0,0 +1,72 @@
+package treesittergo
+
+import "testing"
+
+func TestLuaPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abc", "xabcx", true},
+		{"^abc", "xabc", false},
+		{"^abc$", "abc", true},
+		{"abc$", "abcd", false},
+		{"a.c", "abc", true},
+		{"^%d+$", "12345", true},
+		{"^%d+$", "12a45", false},
+		{"^%a%w*$", "a1b2", true},
+		{"^%A+$", "123", true},
+		{"^%s*$", " \t\n", true},
+		{"^%x+$", "dEaDbEeF", true},
+		{"^%p$", "!", true},
+		{"^%u%l+$", "Hello", true},
+		{"^%u%l+$", "hello", false},
+		{"%.", "a.b", true},
+		{"%.", "ab", false},
+		{"^[abc]+$", "cab", true},
+		{"^[^abc]+$", "xyz", true},
+		{"^[^abc]+$", "xaz", false},
+		{"^[a-f0-9]+$", "c0ffee", true},
+		{"^[%d_]+$", "1_000", true},
+		{"^[]]$", "]", true},
+		{"^a*$", "", true},
+		{"^a-b$", "aaab", true},
+		{"^a?b$", "b", true},
+		{"^a?b$", "aab", false},
+		{"^(a+)%1$", "aaaa", true},
+		{"^(a+)%1$", "aaa", false},
+		{"^()a$", "a", true},
+		{"%b()", "f(a(b)c)", true},
+		{"%b()", "f(a", false},
+		{"%f[%w]%w+", "  word", true},
+		{"%f[%a]b", "ab", false},
+		{"$^", "$^", true},
+	} {
+		lp, err := compileLuaPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compileLuaPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := lp.MatchString(tt.s); got != tt.want {
+			t.Errorf("%q.MatchString(%q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCompileLuaPatternErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"abc%",
+		"[abc",
+		"[%",
+		"(abc",
+		"abc)",
+		"%b(",
+		"%fa",
+		"%1",
+		"(a%1)",
+	} {
+		if _, err := compileLuaPattern(pattern); err == nil {
+			t.Errorf("compileLuaPattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
@@ -2,29 +2,72 @@ package treesittergo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
 )
 
+// tsNode holds the contents of a TSNode struct: four uint32 context
+// values, the node id and the tree pointer.
+type tsNode [24]byte
+
+// Node is a syntax node. It holds a copy of the guest TSNode, so it stays
+// valid after the cursor or the call that produced it moves on, for as
+// long as its tree is open.
 type Node struct {
 	t Treesitter
-	n uint64
+	// closed is the closed flag of the tree of the node.
+	closed *bool
+	n      tsNode
 }
 
-func newNode(t Treesitter, n uint64) Node {
-	return Node{t, n}
+func newNode(t Treesitter, closed *bool, n tsNode) Node {
+	return Node{t, closed, n}
 }
 
-func (t Treesitter) allocateNode(ctx context.Context) (uint64, error) {
-	// allocate tsnode 24 bytes
-	nodePtr, err := t.malloc.Call(ctx, uint64(24))
-	if err != nil {
-		return 0, fmt.Errorf("allocating node: %w", err)
+// checkOpen returns ErrClosed once the tree of the node is closed, as the
+// guest memory the node points to is freed.
+func (n Node) checkOpen() error {
+	if n.closed == nil || *n.closed {
+		return ErrClosed
+	}
+	return n.t.checkOpen()
+}
+
+// writeNode copies n into the scratch node slot and returns its address.
+func (t Treesitter) writeNode(n tsNode) (uint64, error) {
+	if !t.m.Memory().Write(uint32(t.nodePtr), n[:]) {
+		return 0, errors.New("writing node")
+	}
+	return t.nodePtr, nil
+}
+
+// readNode copies the TSNode at ptr out of guest memory.
+func (t Treesitter) readNode(ptr uint64) (tsNode, error) {
+	var n tsNode
+	b, ok := t.m.Memory().Read(uint32(ptr), uint32(len(n)))
+	if !ok {
+		return n, errors.New("reading node")
 	}
-	return nodePtr[0], nil
+	copy(n[:], b)
+	return n, nil
+}
+
+// resultNodePtr is the scratch slot functions returning a TSNode write to.
+func (t Treesitter) resultNodePtr() uint64 {
+	return t.nodePtr + 24
 }
 
 func (n Node) Kind(ctx context.Context) (string, error) {
-	nodeTypeStrPtr, err := n.t.nodeType.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return "", fmt.Errorf("getting node type: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return "", fmt.Errorf("getting node type: %w", err)
+	}
+	nodeTypeStrPtr, err := n.t.nodeType.Call(ctx, nodePtr)
 	if err != nil {
 		return "", fmt.Errorf("getting node type: %w", err)
 	}
@@ -32,31 +75,52 @@ func (n Node) Kind(ctx context.Context) (string, error) {
 }
 
 func (n Node) Child(ctx context.Context, index uint64) (Node, error) {
-	nodePtr, err := n.t.allocateNode(ctx)
+	if err := n.checkOpen(); err != nil {
+		return Node{}, fmt.Errorf("getting node child: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return Node{}, fmt.Errorf("getting node child: %w", err)
+	}
+	_, err = n.t.nodeChild.Call(ctx, n.t.resultNodePtr(), nodePtr, index)
 	if err != nil {
-		return Node{}, err
+		return Node{}, fmt.Errorf("getting node child: %w", err)
 	}
-	_, err = n.t.nodeChild.Call(ctx, nodePtr, n.n, index)
+	child, err := n.t.readNode(n.t.resultNodePtr())
 	if err != nil {
 		return Node{}, fmt.Errorf("getting node child: %w", err)
 	}
-	return newNode(n.t, nodePtr), nil
+	return newNode(n.t, n.closed, child), nil
 }
 
 func (n Node) NamedChild(ctx context.Context, index uint64) (Node, error) {
-	nodePtr, err := n.t.allocateNode(ctx)
+	if err := n.checkOpen(); err != nil {
+		return Node{}, fmt.Errorf("getting node child: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
 	if err != nil {
-		return Node{}, err
+		return Node{}, fmt.Errorf("getting node child: %w", err)
+	}
+	_, err = n.t.nodeNamedChild.Call(ctx, n.t.resultNodePtr(), nodePtr, index)
+	if err != nil {
+		return Node{}, fmt.Errorf("getting node child: %w", err)
 	}
-	_, err = n.t.nodeNamedChild.Call(ctx, nodePtr, n.n, index)
+	child, err := n.t.readNode(n.t.resultNodePtr())
 	if err != nil {
 		return Node{}, fmt.Errorf("getting node child: %w", err)
 	}
-	return newNode(n.t, nodePtr), nil
+	return newNode(n.t, n.closed, child), nil
 }
 
 func (n Node) IsError(ctx context.Context) (bool, error) {
-	res, err := n.t.nodeIsError.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return false, fmt.Errorf("getting node is error: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return false, fmt.Errorf("getting node is error: %w", err)
+	}
+	res, err := n.t.nodeIsError.Call(ctx, nodePtr)
 	if err != nil {
 		return false, fmt.Errorf("getting node is error: %w", err)
 	}
@@ -64,7 +128,14 @@ func (n Node) IsError(ctx context.Context) (bool, error) {
 }
 
 func (n Node) StartByte(ctx context.Context) (uint64, error) {
-	res, err := n.t.nodeStartByte.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return 0, fmt.Errorf("getting node start byte: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return 0, fmt.Errorf("getting node start byte: %w", err)
+	}
+	res, err := n.t.nodeStartByte.Call(ctx, nodePtr)
 	if err != nil {
 		return 0, fmt.Errorf("getting node start byte: %w", err)
 	}
@@ -72,15 +143,49 @@ func (n Node) StartByte(ctx context.Context) (uint64, error) {
 }
 
 func (n Node) EndByte(ctx context.Context) (uint64, error) {
-	res, err := n.t.nodeEndByte.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return 0, fmt.Errorf("getting node end byte: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return 0, fmt.Errorf("getting node end byte: %w", err)
+	}
+	res, err := n.t.nodeEndByte.Call(ctx, nodePtr)
 	if err != nil {
 		return 0, fmt.Errorf("getting node end byte: %w", err)
 	}
 	return res[0], nil
 }
 
+// point returns the point fn, ts_node_start_point or ts_node_end_point,
+// writes to its result pointer.
+func (n Node) point(ctx context.Context, fn api.Function) (Point, error) {
+	if err := n.checkOpen(); err != nil {
+		return Point{}, err
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return Point{}, err
+	}
+	if _, err := fn.Call(ctx, n.t.resultNodePtr(), nodePtr); err != nil {
+		return Point{}, err
+	}
+	b, ok := n.t.m.Memory().Read(uint32(n.t.resultNodePtr()), 8)
+	if !ok {
+		return Point{}, errors.New("invalid point")
+	}
+	return readPoint(b), nil
+}
+
 func (n Node) ChildCount(ctx context.Context) (uint64, error) {
-	res, err := n.t.nodeChildCount.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return 0, fmt.Errorf("getting node child count: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return 0, fmt.Errorf("getting node child count: %w", err)
+	}
+	res, err := n.t.nodeChildCount.Call(ctx, nodePtr)
 	if err != nil {
 		return 0, fmt.Errorf("getting node child count: %w", err)
 	}
@@ -88,17 +193,33 @@ func (n Node) ChildCount(ctx context.Context) (uint64, error) {
 }
 
 func (n Node) NamedChildCount(ctx context.Context) (uint64, error) {
-	res, err := n.t.nodeChildCount.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return 0, fmt.Errorf("getting node named child count: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
 	if err != nil {
-		return 0, fmt.Errorf("getting node child count: %w", err)
+		return 0, fmt.Errorf("getting node named child count: %w", err)
+	}
+	res, err := n.t.nodeNamedChildCount.Call(ctx, nodePtr)
+	if err != nil {
+		return 0, fmt.Errorf("getting node named child count: %w", err)
 	}
 	return res[0], nil
 }
 
 func (n Node) String(ctx context.Context) (string, error) {
-	strPtr, err := n.t.nodeString.Call(ctx, n.n)
+	if err := n.checkOpen(); err != nil {
+		return "", fmt.Errorf("getting node string: %w", err)
+	}
+	nodePtr, err := n.t.writeNode(n.n)
+	if err != nil {
+		return "", fmt.Errorf("getting node string: %w", err)
+	}
+	strPtr, err := n.t.nodeString.Call(ctx, nodePtr)
 	if err != nil {
 		return "", fmt.Errorf("getting node string: %w", err)
 	}
+	// ts_node_string allocates the returned string
+	defer n.t.free.Call(ctx, strPtr[0])
 	return n.t.readString(ctx, strPtr[0])
 }
@@ -0,0 +1,48 @@
+package treesittergo
+
+import (
+	"context"
+	"testing"
+	"unsafe"
+)
+
+func TestNodeSize(t *testing.T) {
+	// a Node is copied by value through every traversal
+	if size := unsafe.Sizeof(Node{}); size > 128 {
+		t.Errorf("unsafe.Sizeof(Node{}) = %d, want at most 128", size)
+	}
+}
+
+func TestNodeChildCounts(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+	tree, err := p.ParseString(ctx, "select a, b from t;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement, err := root.Child(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, err := statement.Child(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (select (keyword_select) (select_expression ...))
+	exprs, err := sel.Child(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (select_expression (term) "," (term))
+	if n, err := exprs.ChildCount(ctx); err != nil || n != 3 {
+		t.Errorf("ChildCount() = %d, %v, want 3", n, err)
+	}
+	if n, err := exprs.NamedChildCount(ctx); err != nil || n != 2 {
+		t.Errorf("NamedChildCount() = %d, %v, want 2", n, err)
+	}
+}
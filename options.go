@@ -0,0 +1,220 @@
+package treesittergo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+type (
+	// Option configures New and NewPool.
+	Option func(*config) error
+
+	config struct {
+		cache            wazero.CompilationCache
+		engine           engine
+		memoryLimitPages uint32
+		closeOnDone      bool
+		runtime          wazero.Runtime
+		stdout           io.Writer
+		stderr           io.Writer
+	}
+
+	engine int
+)
+
+const (
+	engineAuto engine = iota
+	engineCompiler
+	engineInterpreter
+)
+
+var (
+	sharedCache = sync.OnceValue(wazero.NewCompilationCache)
+
+	dirCachesMu sync.Mutex
+	dirCaches   = map[string]wazero.CompilationCache{}
+)
+
+// WithCompilationCache compiles the embedded wasm module through cache.
+// The cache is owned by the caller, who must close it after every
+// Treesitter using it is closed.
+func WithCompilationCache(cache wazero.CompilationCache) Option {
+	return func(c *config) error {
+		c.cache = cache
+		return nil
+	}
+}
+
+// WithCompilationCacheDir persists the compiled wasm module in dir, so
+// later processes skip compilation. Calls with the same dir in one process
+// also share the compiled module in memory.
+func WithCompilationCacheDir(dir string) Option {
+	return func(c *config) error {
+		dirCachesMu.Lock()
+		defer dirCachesMu.Unlock()
+		cache, ok := dirCaches[dir]
+		if !ok {
+			var err error
+			cache, err = wazero.NewCompilationCacheWithDir(dir)
+			if err != nil {
+				return fmt.Errorf("creating compilation cache in %s: %w", dir, err)
+			}
+			dirCaches[dir] = cache
+		}
+		c.cache = cache
+		return nil
+	}
+}
+
+// WithSharedCompilationCache uses an in-memory compilation cache shared by
+// every New and NewPool call of the process using this option, so the
+// module is compiled only once.
+func WithSharedCompilationCache() Option {
+	return func(c *config) error {
+		c.cache = sharedCache()
+		return nil
+	}
+}
+
+// WithCompiler compiles the wasm module to machine code, and must only be
+// used on platforms the wazero compiler supports. By default the compiler
+// is used when supported and the interpreter otherwise.
+func WithCompiler() Option {
+	return func(c *config) error {
+		c.engine = engineCompiler
+		return nil
+	}
+}
+
+// WithInterpreter interprets the wasm module instead of compiling it,
+// which starts faster and works on every platform but parses slower.
+func WithInterpreter() Option {
+	return func(c *config) error {
+		c.engine = engineInterpreter
+		return nil
+	}
+}
+
+// WithMemoryLimitPages caps the linear memory of each instance to pages
+// of 64 KiB. The embedded module needs at least 512 pages to start.
+func WithMemoryLimitPages(pages uint32) Option {
+	return func(c *config) error {
+		c.memoryLimitPages = pages
+		return nil
+	}
+}
+
+// WithCloseOnContextDone interrupts guest execution as soon as the context
+// of a call is done. The instance running the call is closed when that
+// happens: its objects return ErrClosed and a partial parse cannot be
+// resumed. Without it, parsing checks its context between short time
+// slices, and a parse stopped by its context is resumed by the next parse
+// of the same text with the same old tree, see Parser.SetTimeout.
+func WithCloseOnContextDone() Option {
+	return func(c *config) error {
+		c.closeOnDone = true
+		return nil
+	}
+}
+
+// WithRuntime instantiates the module in r instead of a new runtime.
+// Compilation cache, engine, memory limit and close on context done options
+// are ignored, as they are properties of r. The caller owns r: closing a
+// Treesitter or Pool only closes its module instances.
+func WithRuntime(r wazero.Runtime) Option {
+	return func(c *config) error {
+		c.runtime = r
+		return nil
+	}
+}
+
+// WithStdout sends what the module writes to stdout to w. It is discarded
+// by default.
+func WithStdout(w io.Writer) Option {
+	return func(c *config) error {
+		c.stdout = w
+		return nil
+	}
+}
+
+// WithStderr sends what the module writes to stderr to w. It is discarded
+// by default.
+func WithStderr(w io.Writer) Option {
+	return func(c *config) error {
+		c.stderr = w
+		return nil
+	}
+}
+
+func newConfig(opts []Option) (config, error) {
+	var c config
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return config{}, err
+		}
+	}
+	return c, nil
+}
+
+// newRuntime returns the runtime to instantiate the module in, and whether
+// it is owned by the caller of newRuntime.
+func (c config) newRuntime(ctx context.Context) (r wazero.Runtime, owned bool) {
+	if c.runtime != nil {
+		return c.runtime, false
+	}
+
+	var rc wazero.RuntimeConfig
+	switch c.engine {
+	case engineCompiler:
+		rc = wazero.NewRuntimeConfigCompiler()
+	case engineInterpreter:
+		rc = wazero.NewRuntimeConfigInterpreter()
+	default:
+		rc = wazero.NewRuntimeConfig()
+	}
+	if c.cache != nil {
+		rc = rc.WithCompilationCache(c.cache)
+	}
+	if c.memoryLimitPages != 0 {
+		rc = rc.WithMemoryLimitPages(c.memoryLimitPages)
+	}
+	if c.closeOnDone {
+		rc = rc.WithCloseOnContextDone(true)
+	}
+	return wazero.NewRuntimeWithConfig(ctx, rc), true
+}
+
+func (c config) moduleConfig(stderr *switchWriter) wazero.ModuleConfig {
+	// parse timeouts read the clocks, which are fake unless set
+	mc := wazero.NewModuleConfig().
+		WithName("").
+		WithStderr(stderr).
+		WithSysNanotime().
+		WithSysWalltime()
+	if c.stdout != nil {
+		mc = mc.WithStdout(c.stdout)
+	}
+	return mc
+}
+
+// switchWriter writes to target when set, and to base otherwise. It lets a
+// parser temporarily take the stderr output of its module.
+type switchWriter struct {
+	base   io.Writer
+	target io.Writer
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	switch {
+	case w.target != nil:
+		return w.target.Write(p)
+	case w.base != nil:
+		return w.base.Write(p)
+	default:
+		return len(p), nil
+	}
+}
@@ -0,0 +1,56 @@
+package treesittergo
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCompilationCacheDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	newTimed := func() time.Duration {
+		t.Helper()
+		start := time.Now()
+		ts, err := New(ctx, WithCompilationCacheDir(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		if err := ts.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return elapsed
+	}
+	entries := func() []string {
+		t.Helper()
+		var files []string
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	first := newTimed()
+	cached := entries()
+	if len(cached) == 0 {
+		t.Fatal("first New wrote nothing to the cache dir")
+	}
+	second := newTimed()
+	if got := entries(); !slices.Equal(got, cached) {
+		t.Errorf("second New changed the cache dir: got %v, want %v", got, cached)
+	}
+	if second >= first {
+		t.Errorf("second New took %v, not less than the first %v", second, first)
+	}
+}
@@ -2,27 +2,133 @@ package treesittergo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/maphash"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
 )
 
-type Parser struct {
-	t Treesitter
-	p uint64
-}
+type (
+	Parser struct {
+		t Treesitter
+		p uint64
+		s *parserState
+	}
+
+	// parserState is the Go side state of a parser, shared by its copies.
+	parserState struct {
+		closed bool
+		// includedRanges mirrors the ranges set in the guest parser, nil
+		// for the whole document.
+		includedRanges []Range
+		logger         *slog.Logger
+		dotGraphs      io.Writer
+		// language is the language set in the guest parser, 0 if none.
+		language uint64
+		timeout  time.Duration
+		// paused identifies the input of the partial parse kept by the
+		// guest parser after a parse was stopped, if hasPaused is set.
+		paused    parseInput
+		hasPaused bool
+	}
+
+	// parseInput identifies the input of a parse: its old tree, and the
+	// size and hash of its text. The text of an Input is not known, so
+	// its parses set input and never match another.
+	parseInput struct {
+		oldTree uint64
+		size    uint64
+		hash    uint64
+		input   bool
+	}
+
+	// parseText is the text of a parse: the size bytes at ptr in guest
+	// memory, or, when input is set, the document input reads through
+	// the TSInput at ptr, with size 0 if unknown.
+	parseText struct {
+		ptr   uint64
+		size  uint32
+		input *inputReader
+	}
+)
+
+// parseSeed seeds the hashes of parseInput.
+var parseSeed = maphash.MakeSeed()
+
+type (
+	// ParseOptions configures a single parse. The parse methods accept at
+	// most one.
+	ParseOptions struct {
+		// Progress is called each time the parse has run for
+		// ProgressInterval, which tree-sitter checks every 100 parse
+		// actions, and once more when it finishes. Returning false stops
+		// the parse with ErrParseCancelled, keeping it resumable like a
+		// cancelled context does: only by a parse of the same text with
+		// the same old tree.
+		Progress func(ParseState) bool
+		// ProgressInterval defaults to 20 milliseconds.
+		ProgressInterval time.Duration
+	}
+
+	// ParseState is the progress of a parse passed to
+	// ParseOptions.Progress.
+	ParseState struct {
+		// CurrentByteOffset is the offset the lexer has reached in the
+		// parsed text.
+		CurrentByteOffset uint32
+		// Size is the size of the parsed text in bytes, or 0 while
+		// parsing an Input of unknown size.
+		Size uint32
+	}
+)
+
+// parseSlice is how long a parse runs in the guest before checking whether
+// its context is done, unless ParseOptions.ProgressInterval is set.
+const parseSlice = 20 * time.Millisecond
+
+var (
+	// ErrNoLanguage is returned when parsing without a language, or when
+	// using a zero Language.
+	ErrNoLanguage = errors.New("no language set")
+	// ErrParseTimeout is returned when a parse takes longer than the
+	// timeout set with Parser.SetTimeout.
+	ErrParseTimeout = errors.New("parse timed out")
+	// ErrParseCancelled is returned when the context of a parse is done
+	// before the parse finishes. It also wraps the context error. The
+	// partial parse is resumed by the next parse if it has the same text
+	// and old tree, and discarded otherwise.
+	ErrParseCancelled = errors.New("parse cancelled")
+)
 
 func (t Treesitter) NewParser(ctx context.Context) (Parser, error) {
+	if err := t.checkOpen(); err != nil {
+		return Parser{}, fmt.Errorf("creating parser: %w", err)
+	}
 	p, err := t.parserNew.Call(ctx)
 	if err != nil {
 		return Parser{}, fmt.Errorf("creating parser: %w", err)
 	}
+	if p[0] == 0 {
+		return Parser{}, fmt.Errorf("creating parser: %w", ErrOutOfGuestMemory)
+	}
 
 	return Parser{
 		t: t,
 		p: p[0],
+		s: &parserState{},
 	}, nil
 }
 
 func (p Parser) Close(ctx context.Context) error {
+	if p.s == nil || p.s.closed || p.t.checkOpen() != nil {
+		return nil
+	}
+	p.s.closed = true
 	_, err := p.t.parserDelete.Call(ctx, p.p)
 	if err != nil {
 		return fmt.Errorf("closing parser: %w", err)
@@ -30,7 +136,23 @@ func (p Parser) Close(ctx context.Context) error {
 	return nil
 }
 
+func (p Parser) checkOpen() error {
+	if p.s == nil || p.s.closed {
+		return ErrClosed
+	}
+	return p.t.checkOpen()
+}
+
 func (p Parser) SetLanguage(ctx context.Context, l Language) error {
+	if err := p.checkOpen(); err != nil {
+		return fmt.Errorf("setting language: %w", err)
+	}
+	if l.l == 0 {
+		return fmt.Errorf("setting language: %w", ErrNoLanguage)
+	}
+	if err := p.t.checkSame(l.t); err != nil {
+		return fmt.Errorf("setting language: %w", err)
+	}
 	ok, err := p.t.parserSetLanguage.Call(ctx, p.p, l.l)
 	if err != nil {
 		return fmt.Errorf("setting language: %w", err)
@@ -42,10 +164,17 @@ func (p Parser) SetLanguage(ctx context.Context, l Language) error {
 		}
 		return LanguageError{v}
 	}
+	p.s.language = l.l
 	return nil
 }
 
 func (p Parser) GetLanguageVersion(ctx context.Context, l Language) (uint64, error) {
+	if err := p.checkOpen(); err != nil {
+		return 0, fmt.Errorf("getting language version: %w", err)
+	}
+	if l.l == 0 {
+		return 0, fmt.Errorf("getting language version: %w", ErrNoLanguage)
+	}
 	v, err := p.t.languageVersion.Call(ctx, l.l)
 	if err != nil {
 		return 0, fmt.Errorf("getting language version: %w", err)
@@ -53,13 +182,378 @@ func (p Parser) GetLanguageVersion(ctx context.Context, l Language) (uint64, err
 	return v[0], nil
 }
 
-func (p Parser) ParseString(ctx context.Context, str string) (Tree, error) {
+func (p Parser) ParseString(ctx context.Context, str string, opts ...ParseOptions) (Tree, error) {
+	return p.ParseStringWithOldTree(ctx, Tree{}, str, opts...)
+}
+
+// ParseStringWithOldTree parses str reusing the unchanged parts of oldTree.
+// oldTree must have been edited with Tree.Edit to describe every change
+// made to its source text since it was parsed. A zero Tree parses from
+// scratch.
+func (p Parser) ParseStringWithOldTree(
+	ctx context.Context,
+	oldTree Tree,
+	str string,
+	opts ...ParseOptions,
+) (Tree, error) {
+	if err := p.checkParse(oldTree); err != nil {
+		return Tree{}, fmt.Errorf("parsing string: %w", err)
+	}
 	strPtr, strSize, freeStr, err := p.t.allocateString(ctx, str)
+	if err != nil {
+		return Tree{}, fmt.Errorf("parsing string: %w", err)
+	}
 	defer freeStr()
 
-	tree, err := p.t.parserParseString.Call(ctx, p.p, uint64(0), strPtr, strSize)
+	return p.parse(ctx, oldTree, parseText{ptr: strPtr, size: uint32(strSize)}, opts)
+}
+
+func (p Parser) checkParse(oldTree Tree) error {
+	if err := p.checkOpen(); err != nil {
+		return err
+	}
+	if p.s.language == 0 {
+		return ErrNoLanguage
+	}
+	if oldTree.t != 0 {
+		if err := oldTree.checkOpen(); err != nil {
+			return fmt.Errorf("old tree: %w", err)
+		}
+		if err := p.t.checkSame(oldTree.ts); err != nil {
+			return fmt.Errorf("old tree: %w", err)
+		}
+	}
+	return nil
+}
+
+// parse parses text.
+//
+// When the parse can be cancelled or timed out, the guest parser runs in
+// slices of parseSlice: tree-sitter stops and returns a NULL tree when the
+// guest timeout expires, keeps its partial parse, and resumes it on the
+// next call whatever its text, so the partial parse is reset when the
+// input differs from the one it was stopped on.
+func (p Parser) parse(ctx context.Context, oldTree Tree, text parseText, opts []ParseOptions) (Tree, error) {
+	var o ParseOptions
+	if len(opts) > 0 {
+		o = opts[len(opts)-1]
+	}
+	interval := parseSlice
+	if o.ProgressInterval > 0 {
+		interval = o.ProgressInterval
+	}
+
+	start := time.Now()
+	p.log(ctx, "parse started",
+		slog.Uint64("bytes", uint64(text.size)),
+		slog.Bool("incremental", oldTree.t != 0))
+
+	if p.s.dotGraphs != nil {
+		p.t.stderr.target = p.s.dotGraphs
+		defer func() { p.t.stderr.target = nil }()
+	}
+
+	input := parseInput{oldTree: oldTree.t, input: true}
+	if text.input == nil {
+		b, ok := p.t.m.Memory().Read(uint32(text.ptr), text.size)
+		if !ok {
+			return Tree{}, errors.New("invalid parse text")
+		}
+		input = parseInput{oldTree: oldTree.t, size: uint64(text.size), hash: maphash.Bytes(parseSeed, b)}
+	}
+	if p.s.hasPaused && (p.s.paused != input || input.input) {
+		if err := p.Reset(ctx); err != nil {
+			return Tree{}, err
+		}
+	}
+	// until the parse returns a tree or fails, the guest keeps it paused
+	p.s.paused, p.s.hasPaused = input, true
+
+	sliced := ctx.Done() != nil || p.s.timeout > 0 || o.Progress != nil
+	if sliced {
+		defer p.setGuestTimeout(context.WithoutCancel(ctx), 0)
+	}
+	for {
+		if sliced {
+			if err := ctx.Err(); err != nil {
+				p.log(ctx, "parse paused", slog.Any("error", err))
+				return Tree{}, fmt.Errorf("%w: %w", ErrParseCancelled, err)
+			}
+			if p.s.timeout > 0 && time.Since(start) >= p.s.timeout {
+				p.log(ctx, "parse paused", slog.Any("error", ErrParseTimeout))
+				return Tree{}, ErrParseTimeout
+			}
+
+			slice := interval
+			if p.s.timeout > 0 {
+				slice = min(slice, max(p.s.timeout-time.Since(start), time.Microsecond))
+			}
+			if err := p.setGuestTimeout(ctx, slice); err != nil {
+				return Tree{}, err
+			}
+		}
+
+		tree, err := p.parseCall(ctx, oldTree, text)
+		if err != nil || tree != 0 || !sliced {
+			p.s.hasPaused = false
+		}
+		if err == nil && text.input != nil && text.input.err != nil {
+			if tree != 0 {
+				p.t.treeDelete.Call(ctx, tree)
+			}
+			err = text.input.err
+			p.log(ctx, "parse failed", slog.Any("error", err))
+			return Tree{}, err
+		}
+		if err != nil {
+			p.log(ctx, "parse failed", slog.Any("error", err))
+			// the module was closed by WithCloseOnContextDone
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return Tree{}, fmt.Errorf("%w: %w", ErrParseCancelled, err)
+			}
+			return Tree{}, err
+		}
+		if tree == 0 && sliced {
+			// a NULL tree also comes from an external scanner error, which
+			// resets the parser instead of keeping the parse
+			outstanding, err := p.t.parserHasOutstanding.Call(ctx, p.p)
+			if err != nil {
+				p.s.hasPaused = false
+				return Tree{}, fmt.Errorf("checking for a paused parse: %w", err)
+			}
+			if outstanding[0] == 0 {
+				p.s.hasPaused = false
+				p.log(ctx, "parse failed", slog.Any("error", ErrNullTree))
+				return Tree{}, ErrNullTree
+			}
+		}
+		if tree == 0 && !sliced {
+			p.log(ctx, "parse failed", slog.Any("error", ErrNullTree))
+			return Tree{}, ErrNullTree
+		}
+		if tree != 0 {
+			p.log(ctx, "parse finished", slog.Duration("duration", time.Since(start)))
+			if o.Progress != nil {
+				size := text.size
+				if text.input != nil && size == 0 {
+					size = text.input.end
+				}
+				o.Progress(ParseState{CurrentByteOffset: size, Size: size})
+			}
+			return newTree(p.t, tree, p.s.includedRanges), nil
+		}
+
+		if o.Progress != nil {
+			offset, err := p.currentByteOffset(ctx)
+			if err != nil {
+				return Tree{}, err
+			}
+			if !o.Progress(ParseState{CurrentByteOffset: offset, Size: text.size}) {
+				p.log(ctx, "parse paused", slog.String("reason", "progress"))
+				return Tree{}, fmt.Errorf("%w: by progress callback", ErrParseCancelled)
+			}
+		}
+	}
+}
+
+// parseCall calls ts_parser_parse_string, or ts_parser_parse with the
+// inputReader of an input in the context for the input_read host function.
+// The logger of the parser is passed in the context to the log host
+// function.
+func (p Parser) parseCall(ctx context.Context, oldTree Tree, text parseText) (uint64, error) {
+	if p.s.logger != nil {
+		ctx = context.WithValue(ctx, loggerKey{}, p.s.logger)
+	}
+	if text.input != nil {
+		tree, err := p.t.parserParse.Call(context.WithValue(ctx, inputReaderKey{}, text.input), p.p, oldTree.t, text.ptr)
+		if err != nil {
+			return 0, fmt.Errorf("calling ts_parser_parse: %w", err)
+		}
+		return tree[0], nil
+	}
+	tree, err := p.t.parserParseString.Call(ctx, p.p, oldTree.t, text.ptr, uint64(text.size))
+	if err != nil {
+		return 0, fmt.Errorf("calling ts_parser_parse_string: %w", err)
+	}
+	return tree[0], nil
+}
+
+// currentByteOffset returns the position of the lexer of a paused parse.
+func (p Parser) currentByteOffset(ctx context.Context) (uint32, error) {
+	offset, err := p.t.parserCurrentByteOffset.Call(ctx, p.p)
+	if err != nil {
+		return 0, fmt.Errorf("getting lexer position: %w", err)
+	}
+	return uint32(offset[0]), nil
+}
+
+// SetTimeout sets how long a parse may run before it stops with
+// ErrParseTimeout. Zero means no timeout.
+//
+// A parse stopped with ErrParseTimeout or ErrParseCancelled is kept by
+// the parser: calling a parse method again with the same text and old tree
+// resumes it, while a parse of another text or old tree, or Reset,
+// discards it.
+func (p Parser) SetTimeout(timeout time.Duration) {
+	if p.s == nil {
+		return
+	}
+	p.s.timeout = max(timeout, 0)
+}
+
+// Timeout returns the timeout set with SetTimeout.
+func (p Parser) Timeout() time.Duration {
+	if p.s == nil {
+		return 0
+	}
+	return p.s.timeout
+}
+
+func (p Parser) setGuestTimeout(ctx context.Context, timeout time.Duration) error {
+	_, err := p.t.parserSetTimeoutMicros.Call(ctx, p.p, uint64(timeout.Microseconds()))
+	if err != nil {
+		return fmt.Errorf("setting parser timeout: %w", err)
+	}
+	return nil
+}
+
+// Reset discards the partial parse kept after ErrParseTimeout or
+// ErrParseCancelled, so the next parse starts from the beginning.
+func (p Parser) Reset(ctx context.Context) error {
+	if err := p.checkOpen(); err != nil {
+		return fmt.Errorf("resetting parser: %w", err)
+	}
+	_, err := p.t.parserReset.Call(ctx, p.p)
+	if err != nil {
+		return fmt.Errorf("resetting parser: %w", err)
+	}
+	p.s.hasPaused = false
+	return nil
+}
+
+// SetLogger sends the log of the parser to logger at debug level: the
+// lexing and parsing steps of tree-sitter, and when each parse call
+// starts, pauses, fails or finishes. A "type" attribute tells "lex" events
+// from "parse" ones. A nil logger disables logging.
+func (p Parser) SetLogger(ctx context.Context, logger *slog.Logger) error {
+	if err := p.checkOpen(); err != nil {
+		return fmt.Errorf("setting logger: %w", err)
+	}
+	// tslogger 8 bytes, left zero to disable logging
+	loggerPtr, err := p.t.allocate(ctx, 8)
+	if err != nil {
+		return fmt.Errorf("setting logger: %w", err)
+	}
+	defer p.t.free.Call(ctx, loggerPtr)
+	if !p.t.m.Memory().WriteUint64Le(uint32(loggerPtr), 0) {
+		return errors.New("setting logger: invalid logger")
+	}
+	if logger != nil {
+		if _, err := p.t.loggerInit.Call(ctx, loggerPtr); err != nil {
+			return fmt.Errorf("setting logger: %w", err)
+		}
+	}
+	if _, err := p.t.parserSetLogger.Call(ctx, p.p, loggerPtr); err != nil {
+		return fmt.Errorf("setting logger: %w", err)
+	}
+	p.s.logger = logger
+	return nil
+}
+
+// loggerKey is the context key of the logger of a parse.
+type loggerKey struct{}
+
+// parserLog implements the log callback of TSLogger, logging the message
+// at msg to the logger of the parse.
+func parserLog(ctx context.Context, m api.Module, _, logType, msg uint32) {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return
+	}
+	typ := "parse"
+	if logType == 1 { // TSLogTypeLex
+		typ = "lex"
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, cString(m.Memory(), msg), slog.String("type", typ))
+}
+
+// PrintDotGraphs writes DOT graphs of the parse stack to w during each
+// parse, for debugging a grammar. A nil w disables the graphs.
+func (p Parser) PrintDotGraphs(ctx context.Context, w io.Writer) error {
+	if err := p.checkOpen(); err != nil {
+		return fmt.Errorf("printing dot graphs: %w", err)
+	}
+	if err := requireExport(p.t.parserPrintDotGraphs, "ts_parser_print_dot_graphs"); err != nil {
+		return fmt.Errorf("printing dot graphs: %w", err)
+	}
+	// the graphs are written to the module stderr, which parse routes to
+	// the writer of the parser. Closing the stream of the graphs, as -1
+	// does, leaves the descriptor open.
+	fd := int32(2)
+	if w == nil {
+		fd = -1
+	}
+	if _, err := p.t.parserPrintDotGraphs.Call(ctx, p.p, api.EncodeI32(fd)); err != nil {
+		return fmt.Errorf("printing dot graphs: %w", err)
+	}
+	p.s.dotGraphs = w
+	return nil
+}
+
+// log logs a parse call event to the logger set with SetLogger.
+func (p Parser) log(ctx context.Context, msg string, attrs ...slog.Attr) {
+	if p.s.logger == nil {
+		return
+	}
+	attrs = append([]slog.Attr{slog.String("type", "parse")}, attrs...)
+	p.s.logger.LogAttrs(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// SetIncludedRanges restricts parsing to ranges of the document, which
+// must be sorted and must not overlap. Node positions stay relative to the
+// whole document, and text between the ranges is skipped, so for example
+// the SQL fences of a Markdown file parse as one tree. A nil ranges parses
+// the whole document again.
+func (p Parser) SetIncludedRanges(ctx context.Context, ranges []Range) error {
+	if err := p.checkOpen(); err != nil {
+		return fmt.Errorf("setting included ranges: %w", err)
+	}
+
+	var rangesPtr uint64
+	if len(ranges) > 0 {
+		// tsrange 24 bytes
+		b := make([]byte, 0, len(ranges)*24)
+		for _, r := range ranges {
+			b = appendRange(b, r)
+		}
+		ptr, _, freeRanges, err := p.t.allocateBytes(ctx, b)
+		if err != nil {
+			return fmt.Errorf("allocating included ranges: %w", err)
+		}
+		defer freeRanges()
+		rangesPtr = ptr
+	}
+
+	ok, err := p.t.parserSetIncludedRanges.Call(ctx, p.p, rangesPtr, uint64(len(ranges)))
 	if err != nil {
-		return Tree{}, fmt.Errorf("calling ts_parser_parse_string: %w", err)
+		return fmt.Errorf("setting included ranges: %w", err)
+	}
+	if ok[0] == 0 {
+		return errors.New("setting included ranges: ranges are not sorted or overlap")
+	}
+	if len(ranges) == 0 {
+		p.s.includedRanges = nil
+	} else {
+		p.s.includedRanges = slices.Clone(ranges)
+	}
+	return nil
+}
+
+// IncludedRanges returns the ranges set with SetIncludedRanges, or a single
+// range covering any document if none are set.
+func (p Parser) IncludedRanges() []Range {
+	if p.s == nil {
+		return includedRangesOrAll(nil)
 	}
-	return newTree(p.t, tree[0]), nil
+	return includedRangesOrAll(p.s.includedRanges)
 }
@@ -0,0 +1,228 @@
+package treesittergo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZeroParser(t *testing.T) {
+	var p Parser
+	p.SetTimeout(time.Second)
+	if got := p.Timeout(); got != 0 {
+		t.Errorf("Timeout() = %v, want 0", got)
+	}
+	if err := p.SetLogger(context.Background(), slog.Default()); !errors.Is(err, ErrClosed) {
+		t.Errorf("SetLogger() = %v, want ErrClosed", err)
+	}
+	if got := p.IncludedRanges(); !slices.Equal(got, includedRangesOrAll(nil)) {
+		t.Errorf("IncludedRanges() = %v", got)
+	}
+	if err := p.checkOpen(); err != ErrClosed {
+		t.Errorf("checkOpen() = %v, want ErrClosed", err)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := p.SetLogger(ctx, logger); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := p.ParseString(ctx, "select a from t;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Close(ctx)
+	for _, want := range []string{
+		`msg="parse started" type=parse`,
+		`msg=new_parse type=parse`,
+		`type=lex`,
+		`msg=done type=parse`,
+		`msg="parse finished" type=parse`,
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("log does not contain %q:\n%s", want, buf.Bytes())
+		}
+	}
+
+	buf.Reset()
+	if err := p.SetLogger(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = p.ParseString(ctx, "select a from t;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Close(ctx)
+	if buf.Len() > 0 {
+		t.Errorf("disabled logger logged:\n%s", buf.Bytes())
+	}
+}
+
+func TestPrintDotGraphs(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	parse := func() {
+		t.Helper()
+		tree, err := p.ParseString(ctx, "select 1;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.Close(ctx)
+	}
+
+	var graphs bytes.Buffer
+	if err := p.PrintDotGraphs(ctx, &graphs); err != nil {
+		t.Fatal(err)
+	}
+	parse()
+	if !bytes.Contains(graphs.Bytes(), []byte("digraph stack {")) {
+		t.Fatalf("no graph written:\n%s", graphs.Bytes())
+	}
+
+	graphs.Reset()
+	if err := p.PrintDotGraphs(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	parse()
+	if graphs.Len() > 0 {
+		t.Errorf("disabled graphs written:\n%s", graphs.Bytes())
+	}
+
+	// disabling the graphs left the module stderr open
+	if err := p.PrintDotGraphs(ctx, &graphs); err != nil {
+		t.Fatal(err)
+	}
+	parse()
+	if !bytes.Contains(graphs.Bytes(), []byte("digraph stack {")) {
+		t.Errorf("no graph written after enabling them again:\n%s", graphs.Bytes())
+	}
+}
+
+func TestParseResume(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+	src := strings.Repeat("select a, b from t where c = 1;\n", 2000)
+
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := p.SetLogger(ctx, logger); err != nil {
+		t.Fatal(err)
+	}
+	p.SetTimeout(time.Millisecond)
+	if _, err := p.ParseString(ctx, src); !errors.Is(err, ErrParseTimeout) {
+		t.Fatalf("ParseString = %v, want %v", err, ErrParseTimeout)
+	}
+
+	p.SetTimeout(0)
+	log.Reset()
+	tree, err := p.ParseString(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	if !strings.Contains(log.String(), "msg=resume_parsing") || strings.Contains(log.String(), "msg=new_parse") {
+		t.Error("the parse of the same text was not resumed")
+	}
+	if got := treeString(t, tree); strings.Contains(got, "ERROR") {
+		t.Errorf("resumed parse has errors")
+	}
+
+	// a parse of another text starts over
+	p.SetTimeout(time.Millisecond)
+	if _, err := p.ParseString(ctx, src); !errors.Is(err, ErrParseTimeout) {
+		t.Fatalf("ParseString = %v, want %v", err, ErrParseTimeout)
+	}
+	p.SetTimeout(0)
+	log.Reset()
+	other, err := p.ParseString(ctx, "select 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close(ctx)
+	if strings.Contains(log.String(), "msg=resume_parsing") {
+		t.Error("the parse of another text resumed the paused one")
+	}
+	if got := treeString(t, other); strings.Contains(got, "ERROR") {
+		t.Errorf("parse of another text: %s", got)
+	}
+}
+
+func TestParseProgressInterval(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+	src := strings.Repeat("select a, b from t where c = 1;\n", 20000)
+
+	// the guest timeout of each slice runs on the real clock
+	const interval = 50 * time.Millisecond
+	var calls int
+	start := time.Now()
+	tree, err := p.ParseString(ctx, src, ParseOptions{
+		Progress:         func(ParseState) bool { calls++; return true },
+		ProgressInterval: interval,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	// each slice ends at the first timeout check past its interval, and
+	// a parse of a second or more runs in several
+	elapsed := time.Since(start)
+	if hi := int(elapsed/interval) + 2; calls < 2 || calls > hi {
+		t.Errorf("Progress called %d times in %v, want 2 to %d", calls, elapsed, hi)
+	}
+}
+
+func TestParseProgressOffset(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	// the parser skips to the included range, so the lexer offset starts
+	// past the skipped line
+	skipped := strings.Repeat("#", 99999) + "\n"
+	src := skipped + strings.Repeat("select a, b from t where c = 1;\n", 20000)
+	err := p.SetIncludedRanges(ctx, []Range{{
+		StartPoint: Point{Row: 1},
+		EndPoint:   Point{Row: 20001},
+		StartByte:  uint32(len(skipped)),
+		EndByte:    uint32(len(src)),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var states []ParseState
+	tree, err := p.ParseString(ctx, src, ParseOptions{
+		Progress:         func(s ParseState) bool { states = append(states, s); return true },
+		ProgressInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	if len(states) < 3 {
+		t.Fatalf("Progress called %d times", len(states))
+	}
+
+	last := states[len(states)-1]
+	if want := (ParseState{CurrentByteOffset: uint32(len(src)), Size: uint32(len(src))}); last != want {
+		t.Errorf("last ParseState = %+v, want %+v", last, want)
+	}
+	prev := uint32(len(skipped))
+	for _, s := range states[:len(states)-1] {
+		if s.Size != uint32(len(src)) || s.CurrentByteOffset < prev || s.CurrentByteOffset >= s.Size {
+			t.Fatalf("ParseState %+v after offset %d", s, prev)
+		}
+		prev = s.CurrentByteOffset
+	}
+}
@@ -0,0 +1,182 @@
+package treesittergo
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Pool hands out Treesitter instances to goroutines. The wasm module is
+// compiled once, and every instance has its own linear memory and
+// allocator, so instances can be used concurrently.
+//
+// An instance must only be used by one goroutine at a time, between
+// Acquire and Release. Parsers, languages, trees, nodes, queries and query
+// cursors belong to the instance that created them: they must not cross
+// instances, and passing one to an object of another instance returns
+// ErrInstanceMismatch. Only plain Go values, such as query results read
+// into Go memory, may be shared.
+type Pool struct {
+	r        wazero.Runtime
+	ownsR    bool
+	compiled wazero.CompiledModule
+	c        config
+
+	// slots holds a token for every instance that can be acquired: an idle
+	// one, or room to create one. Release puts the token back, waking a
+	// blocked Acquire.
+	slots chan struct{}
+	// done is closed by Close.
+	done chan struct{}
+
+	mu     sync.Mutex
+	all    []Treesitter
+	idle   []Treesitter
+	closed bool
+}
+
+// NewPool creates a pool of at most size instances. Instances are created
+// on demand. A size of zero or less uses runtime.GOMAXPROCS(0).
+func NewPool(ctx context.Context, size int, opts ...Option) (*Pool, error) {
+	if size <= 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+
+	c, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	r, owned := c.newRuntime(ctx)
+	compiled, err := compile(ctx, r)
+	if err != nil {
+		if owned {
+			r.Close(ctx)
+		}
+		return nil, err
+	}
+
+	slots := make(chan struct{}, size)
+	for range size {
+		slots <- struct{}{}
+	}
+	return &Pool{
+		r:        r,
+		ownsR:    owned,
+		compiled: compiled,
+		c:        c,
+		slots:    slots,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Acquire returns an idle instance, creating one if the pool is not full,
+// or blocks until another goroutine releases one or ctx is done.
+func (p *Pool) Acquire(ctx context.Context) (Treesitter, error) {
+	select {
+	case <-p.slots:
+	case <-p.done:
+		return Treesitter{}, fmt.Errorf("acquiring instance: %w", ErrClosed)
+	case <-ctx.Done():
+		return Treesitter{}, fmt.Errorf("acquiring instance: %w", ctx.Err())
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return Treesitter{}, fmt.Errorf("acquiring instance: %w", ErrClosed)
+	}
+	for len(p.idle) > 0 {
+		t := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if t.checkOpen() == nil {
+			p.mu.Unlock()
+			return t, nil
+		}
+		// closed after it was released: its slot creates a new one
+		p.remove(t)
+	}
+	p.mu.Unlock()
+
+	t, err := instantiate(ctx, p.r, p.compiled, p.c)
+	if err != nil {
+		p.slots <- struct{}{}
+		return Treesitter{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		t.Close(ctx)
+		return Treesitter{}, fmt.Errorf("acquiring instance: %w", ErrClosed)
+	}
+	p.all = append(p.all, t)
+	return t, nil
+}
+
+// remove removes t from the instances of the pool.
+func (p *Pool) remove(t Treesitter) {
+	p.all = slices.DeleteFunc(p.all, func(a Treesitter) bool { return a.m == t.m })
+}
+
+// Release returns t to the pool. Objects created from t should be closed
+// before releasing it, otherwise their guest memory stays allocated until
+// the instance is reused and they are closed by the next owner. Releasing
+// a closed instance frees its slot for a new one. Releasing an instance
+// twice, or one the pool did not create, does nothing.
+func (p *Pool) Release(t Treesitter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	same := func(a Treesitter) bool { return a.m == t.m }
+	if p.closed || !slices.ContainsFunc(p.all, same) || slices.ContainsFunc(p.idle, same) {
+		return
+	}
+	if t.checkOpen() != nil {
+		p.remove(t)
+	} else {
+		p.idle = append(p.idle, t)
+	}
+	// t held a token, so this never blocks
+	p.slots <- struct{}{}
+}
+
+// Do acquires an instance, calls fn with it and releases it.
+func (p *Pool) Do(ctx context.Context, fn func(Treesitter) error) error {
+	t, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Release(t)
+	return fn(t)
+}
+
+// Close closes every instance of the pool, including acquired ones, and
+// the runtime unless it was given with WithRuntime. Acquire returns
+// ErrClosed afterwards.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	if !p.ownsR {
+		for _, t := range p.all {
+			if err := t.Close(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, t := range p.all {
+		t.closed.Store(true)
+	}
+	if err := p.r.Close(ctx); err != nil {
+		return fmt.Errorf("closing runtime: %w", err)
+	}
+	return nil
+}
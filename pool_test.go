@@ -0,0 +1,180 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, size int) *Pool {
+	t.Helper()
+	ctx := context.Background()
+	p, err := NewPool(ctx, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close(ctx) })
+	return p
+}
+
+func TestPoolReleaseClosedWakesWaiter(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPool(t, 1)
+
+	ts, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		ts, err := p.Acquire(ctx)
+		if err == nil {
+			err = ts.checkOpen()
+			p.Release(ts)
+		}
+		acquired <- err
+	}()
+
+	// let the waiter block before freeing the slot
+	time.Sleep(10 * time.Millisecond)
+	if err := ts.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p.Release(ts)
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Acquire was not woken by releasing a closed instance")
+	}
+}
+
+func TestPoolReleaseTwice(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPool(t, 1)
+
+	ts, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release(ts)
+	p.Release(ts)
+
+	a, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.m != ts.m {
+		t.Error("released instance was not reused")
+	}
+
+	// the second release must not have added a slot
+	ctx2, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire on full pool = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPool(t, 2)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 10 {
+				ts, err := p.Acquire(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// close some instances to make the pool replace them
+				if (i+j)%4 == 0 {
+					ts.Close(ctx)
+					p.Release(ts)
+					continue
+				}
+				err = parseSelect(ctx, ts)
+				p.Release(ts)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPoolCloseWakesWaiters(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPool(t, 1)
+
+	ts, err := p.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const waiters = 4
+	errs := make(chan error, waiters)
+	for range waiters {
+		go func() {
+			ts, err := p.Acquire(ctx)
+			if err == nil {
+				p.Release(ts)
+			}
+			errs <- err
+		}()
+	}
+
+	// let the waiters block, then close the pool under them
+	time.Sleep(10 * time.Millisecond)
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p.Release(ts)
+
+	for range waiters {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("Acquire = %v, want %v", err, ErrClosed)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Acquire was not woken by Close")
+		}
+	}
+	if err := ts.checkOpen(); !errors.Is(err, ErrClosed) {
+		t.Errorf("instance after pool Close: checkOpen() = %v, want %v", err, ErrClosed)
+	}
+}
+
+// parseSelect parses a statement with ts, closing everything it creates.
+func parseSelect(ctx context.Context, ts Treesitter) error {
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := ts.NewParser(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Close(ctx)
+	if err := p.SetLanguage(ctx, lang); err != nil {
+		return err
+	}
+	tree, err := p.ParseString(ctx, "select a from t;")
+	if err != nil {
+		return err
+	}
+	return tree.Close(ctx)
+}
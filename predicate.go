@@ -0,0 +1,347 @@
+package treesittergo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Step types of a TSQueryPredicateStep.
+const (
+	predicateStepDone uint32 = iota
+	predicateStepCapture
+	predicateStepString
+)
+
+type (
+	// QueryPredicate is a predicate of a query pattern, such as
+	// (#eq? @name "value").
+	QueryPredicate struct {
+		// Operator is the predicate name without the leading "#", such as
+		// "eq?".
+		Operator string
+		Args     []QueryPredicateArg
+	}
+
+	// QueryPredicateArg is an argument of a predicate: a capture or a
+	// string.
+	QueryPredicateArg struct {
+		// IsCapture reports whether the argument is a capture, identified
+		// by CaptureID.
+		IsCapture bool
+		CaptureID uint32
+		// Value is the capture name without the leading "@", or the
+		// string.
+		Value string
+	}
+
+	// QueryPredicateFunc evaluates a predicate for a match. text is the
+	// text given to QueryCursor.ExecWithText, or nil.
+	QueryPredicateFunc func(ctx context.Context, p QueryPredicate, m QueryMatch, text []byte) (bool, error)
+
+	// queryPredicate is a predicate with its arguments compiled.
+	queryPredicate struct {
+		QueryPredicate
+		re matcher
+	}
+
+	// matcher is a compiled regular expression or Lua pattern.
+	matcher interface {
+		MatchString(s string) bool
+	}
+)
+
+// readPredicates reads the predicates of every pattern of q.
+func (q Query) readPredicates(ctx context.Context, opts QueryOptions) ([][]queryPredicate, error) {
+	for _, fn := range []api.Function{
+		q.t.queryPatternCount, q.t.queryPredicatesForPattern,
+		q.t.queryStringCount, q.t.queryStringValueForID, q.t.queryCaptureCount,
+	} {
+		if fn == nil {
+			// without predicates, every structural match is returned
+			return nil, nil
+		}
+	}
+	strs, err := q.readStrings(ctx, q.t.queryStringCount, q.t.queryStringValueForID)
+	if err != nil {
+		return nil, fmt.Errorf("reading query strings: %w", err)
+	}
+	captures, err := q.readStrings(ctx, q.t.queryCaptureCount, q.t.queryCaptureNameForID)
+	if err != nil {
+		return nil, fmt.Errorf("reading query capture names: %w", err)
+	}
+
+	count, err := q.t.queryPatternCount.Call(ctx, q.q)
+	if err != nil {
+		return nil, fmt.Errorf("getting query pattern count: %w", err)
+	}
+	lenPtr, err := q.t.allocate(ctx, 4)
+	if err != nil {
+		return nil, err
+	}
+	defer q.t.free.Call(ctx, lenPtr)
+
+	predicates := make([][]queryPredicate, count[0])
+	for i := range predicates {
+		stepsPtr, err := q.t.queryPredicatesForPattern.Call(ctx, q.q, uint64(i), lenPtr)
+		if err != nil {
+			return nil, fmt.Errorf("getting predicates for pattern %d: %w", i, err)
+		}
+		stepCount, ok := q.t.m.Memory().ReadUint32Le(uint32(lenPtr))
+		if !ok {
+			return nil, errors.New("invalid predicate step count")
+		}
+		steps, ok := q.t.m.Memory().Read(uint32(stepsPtr[0]), stepCount*8)
+		if !ok {
+			return nil, errors.New("invalid predicate steps")
+		}
+
+		var p QueryPredicate
+		for s := 0; s < len(steps); s += 8 {
+			typ, id := binary.LittleEndian.Uint32(steps[s:]), binary.LittleEndian.Uint32(steps[s+4:])
+			switch {
+			case typ == predicateStepDone:
+				qp, err := compilePredicate(p, opts)
+				if err != nil {
+					return nil, fmt.Errorf("pattern %d: %w", i, err)
+				}
+				predicates[i] = append(predicates[i], qp)
+				p = QueryPredicate{}
+			case int(id) >= len(strs) && typ == predicateStepString,
+				int(id) >= len(captures) && typ == predicateStepCapture:
+				return nil, fmt.Errorf("pattern %d: invalid predicate step id %d", i, id)
+			case p.Operator == "" && typ == predicateStepString:
+				p.Operator = strs[id]
+			case typ == predicateStepString:
+				p.Args = append(p.Args, QueryPredicateArg{Value: strs[id]})
+			default:
+				p.Args = append(p.Args, QueryPredicateArg{IsCapture: true, CaptureID: id, Value: captures[id]})
+			}
+		}
+	}
+	return predicates, nil
+}
+
+// readStrings reads the strings of q returned by value for the ids below
+// the result of count, such as its capture names.
+func (q Query) readStrings(ctx context.Context, count, value api.Function) ([]string, error) {
+	n, err := count.Call(ctx, q.q)
+	if err != nil {
+		return nil, err
+	}
+	lenPtr, err := q.t.allocate(ctx, 4)
+	if err != nil {
+		return nil, err
+	}
+	defer q.t.free.Call(ctx, lenPtr)
+
+	strs := make([]string, n[0])
+	for id := range strs {
+		ptr, err := value.Call(ctx, q.q, uint64(id), lenPtr)
+		if err != nil {
+			return nil, err
+		}
+		length, ok := q.t.m.Memory().ReadUint32Le(uint32(lenPtr))
+		if !ok {
+			return nil, errors.New("invalid string length")
+		}
+		b, ok := q.t.m.Memory().Read(uint32(ptr[0]), length)
+		if !ok {
+			return nil, errors.New("invalid string")
+		}
+		strs[id] = string(b)
+	}
+	return strs, nil
+}
+
+// compilePredicate checks the arguments of the built-in predicates and
+// compiles their regular expressions and Lua patterns. Other predicates
+// are left to the functions set with Query.SetPredicateFunc.
+func compilePredicate(p QueryPredicate, opts QueryOptions) (queryPredicate, error) {
+	qp := queryPredicate{QueryPredicate: p}
+	switch p.Operator {
+	case "eq?", "not-eq?", "any-eq?", "any-not-eq?":
+		if len(p.Args) != 2 || !p.Args[0].IsCapture {
+			return qp, fmt.Errorf("#%s needs a capture and a capture or a string", p.Operator)
+		}
+	case "match?", "not-match?", "any-match?", "any-not-match?",
+		"lua-match?", "not-lua-match?", "any-lua-match?", "any-not-lua-match?":
+		if len(p.Args) != 2 || !p.Args[0].IsCapture || p.Args[1].IsCapture {
+			return qp, fmt.Errorf("#%s needs a capture and a string", p.Operator)
+		}
+		var err error
+		if opts.LuaPatterns || strings.HasSuffix(p.Operator, "lua-match?") {
+			qp.re, err = compileLuaPattern(p.Args[1].Value)
+		} else {
+			qp.re, err = regexp.Compile(p.Args[1].Value)
+		}
+		if err != nil {
+			return qp, fmt.Errorf("#%s: %w", p.Operator, err)
+		}
+	case "any-of?", "not-any-of?":
+		if len(p.Args) < 1 || !p.Args[0].IsCapture {
+			return qp, fmt.Errorf("#%s needs a capture and strings", p.Operator)
+		}
+		for _, arg := range p.Args[1:] {
+			if arg.IsCapture {
+				return qp, fmt.Errorf("#%s needs a capture and strings", p.Operator)
+			}
+		}
+	}
+	return qp, nil
+}
+
+// PredicatesForPattern returns the predicates of the pattern at index,
+// including the ones NextMatch does not evaluate, such as #set!.
+func (q Query) PredicatesForPattern(index uint32) []QueryPredicate {
+	if q.s == nil || int(index) >= len(q.s.predicates) {
+		return nil
+	}
+	predicates := make([]QueryPredicate, len(q.s.predicates[index]))
+	for i, p := range q.s.predicates[index] {
+		predicates[i] = p.QueryPredicate
+		predicates[i].Args = slices.Clone(p.Args)
+	}
+	return predicates
+}
+
+// SetPredicateFunc makes NextMatch evaluate the predicates named operator,
+// without the leading "#", with fn. It overrides the built-in #eq?,
+// #match? and #any-of? predicates and their variants. Predicates without
+// a function, such as #set!, are ignored.
+func (q Query) SetPredicateFunc(operator string, fn QueryPredicateFunc) {
+	if q.s == nil {
+		return
+	}
+	if q.s.predicateFuncs == nil {
+		q.s.predicateFuncs = map[string]QueryPredicateFunc{}
+	}
+	if fn == nil {
+		delete(q.s.predicateFuncs, operator)
+		return
+	}
+	q.s.predicateFuncs[operator] = fn
+}
+
+// satisfies reports whether m satisfies the predicates of its pattern.
+// Text predicates are only evaluated when the cursor was given the text.
+func (q Query) satisfies(ctx context.Context, m QueryMatch, text []byte, hasText bool) (bool, error) {
+	if int(m.PatternIndex) >= len(q.s.predicates) {
+		return true, nil
+	}
+	for _, p := range q.s.predicates[m.PatternIndex] {
+		var (
+			ok  bool
+			err error
+		)
+		if fn := q.s.predicateFuncs[p.Operator]; fn != nil {
+			ok, err = fn(ctx, p.QueryPredicate, m, text)
+		} else if hasText {
+			ok, err = p.eval(ctx, m, text)
+		} else {
+			ok = true
+		}
+		if err != nil {
+			return false, fmt.Errorf("evaluating #%s: %w", p.Operator, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// eval evaluates a built-in predicate. Unknown predicates are satisfied.
+func (p queryPredicate) eval(ctx context.Context, m QueryMatch, text []byte) (bool, error) {
+	var (
+		positive = true
+		all      = true
+		test     func(string) bool
+	)
+	op := p.Operator
+	if rest, ok := strings.CutPrefix(op, "any-"); ok && rest != "of?" {
+		op, all = rest, false
+	}
+	if rest, ok := strings.CutPrefix(op, "not-"); ok {
+		op, positive = rest, false
+	}
+
+	nodes, err := captureTexts(ctx, m, p.Args[0].CaptureID, text)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "eq?":
+		if p.Args[1].IsCapture {
+			others, err := captureTexts(ctx, m, p.Args[1].CaptureID, text)
+			if err != nil {
+				return false, err
+			}
+			n := min(len(nodes), len(others))
+			pairs := make([]bool, n)
+			for i := range n {
+				pairs[i] = (nodes[i] == others[i]) == positive
+			}
+			return quantify(pairs, all), nil
+		}
+		test = func(s string) bool { return s == p.Args[1].Value }
+	case "match?", "lua-match?":
+		test = p.re.MatchString
+	case "any-of?":
+		values := p.Args[1:]
+		test = func(s string) bool {
+			return slices.ContainsFunc(values, func(v QueryPredicateArg) bool { return v.Value == s })
+		}
+	default:
+		return true, nil
+	}
+
+	results := make([]bool, len(nodes))
+	for i, s := range nodes {
+		results[i] = test(s) == positive
+	}
+	return quantify(results, all), nil
+}
+
+// quantify reports whether all or any of results are true. It is true for
+// no results, as a predicate on a missing optional capture holds.
+func quantify(results []bool, all bool) bool {
+	if len(results) == 0 {
+		return true
+	}
+	if all {
+		return !slices.Contains(results, false)
+	}
+	return slices.Contains(results, true)
+}
+
+// captureTexts returns the text of the nodes captured by id in m.
+func captureTexts(ctx context.Context, m QueryMatch, id uint32, text []byte) ([]string, error) {
+	var texts []string
+	for _, c := range m.Captures {
+		if c.ID != id {
+			continue
+		}
+		if err := c.Node.checkOpen(); err != nil {
+			return nil, err
+		}
+		start, err := c.Node.StartByte(ctx)
+		if err != nil {
+			return nil, err
+		}
+		end, err := c.Node.EndByte(ctx)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, uint64(len(text)))
+		start = min(start, end)
+		texts = append(texts, string(text[start:end]))
+	}
+	return texts, nil
+}
@@ -0,0 +1,131 @@
+package treesittergo
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestPredicates(t *testing.T) {
+	ctx := context.Background()
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close(ctx)
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ts.NewParser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguage(ctx, lang); err != nil {
+		t.Fatal(err)
+	}
+	text := []byte("select a, bb, a1, x from t where x = x;")
+	tree, err := p.ParseString(ctx, string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qc, err := ts.NewQueryCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query string
+		opts  QueryOptions
+		want  []string
+	}{
+		{`((identifier) @id (#eq? @id "a"))`, QueryOptions{}, []string{"a"}},
+		{`((identifier) @id (#not-eq? @id "x"))`, QueryOptions{}, []string{"a", "bb", "a1", "t"}},
+		{`((identifier) @id (#match? @id "^a"))`, QueryOptions{}, []string{"a", "a1"}},
+		{`((identifier) @id (#not-match? @id "[0-9]"))`, QueryOptions{}, []string{"a", "bb", "x", "t", "x", "x"}},
+		{`((identifier) @id (#lua-match? @id "^%a%d$"))`, QueryOptions{}, []string{"a1"}},
+		{`((identifier) @id (#match? @id "^%l+$"))`, QueryOptions{LuaPatterns: true}, []string{"a", "bb", "x", "t", "x", "x"}},
+		{`((identifier) @id (#any-of? @id "bb" "t"))`, QueryOptions{}, []string{"bb", "t"}},
+		{`((identifier) @id (#not-any-of? @id "a" "bb" "a1"))`, QueryOptions{}, []string{"x", "t", "x", "x"}},
+		{`(binary_expression left: (field (identifier) @l) right: (field (identifier) @r) (#eq? @l @r))`,
+			QueryOptions{}, []string{"x", "x"}},
+		{`((identifier) @id (#unknown? @id "a"))`, QueryOptions{}, []string{"a", "bb", "a1", "x", "t", "x", "x"}},
+	} {
+		q, err := ts.NewQuery(ctx, tt.query, lang, tt.opts)
+		if err != nil {
+			t.Errorf("NewQuery(%s): %v", tt.query, err)
+			continue
+		}
+		if err := qc.ExecWithText(ctx, q, root, text); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			m, ok, err := qc.NextMatch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			for _, c := range m.Captures {
+				start, _ := c.Node.StartByte(ctx)
+				end, _ := c.Node.EndByte(ctx)
+				got = append(got, string(text[start:end]))
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s matched %q, want %q", tt.query, got, tt.want)
+		}
+		q.Close(ctx)
+	}
+}
+
+func TestCompilePredicate(t *testing.T) {
+	capture := QueryPredicateArg{IsCapture: true, Value: "id"}
+	str := func(s string) QueryPredicateArg { return QueryPredicateArg{Value: s} }
+	for _, tt := range []struct {
+		p       QueryPredicate
+		wantErr bool
+	}{
+		{QueryPredicate{"eq?", []QueryPredicateArg{capture, str("a")}}, false},
+		{QueryPredicate{"eq?", []QueryPredicateArg{capture, capture}}, false},
+		{QueryPredicate{"eq?", []QueryPredicateArg{str("a"), capture}}, true},
+		{QueryPredicate{"any-eq?", []QueryPredicateArg{capture}}, true},
+		{QueryPredicate{"match?", []QueryPredicateArg{capture, str("^a+$")}}, false},
+		{QueryPredicate{"match?", []QueryPredicateArg{capture, str("(")}}, true},
+		{QueryPredicate{"match?", []QueryPredicateArg{capture, capture}}, true},
+		{QueryPredicate{"lua-match?", []QueryPredicateArg{capture, str("%a+(")}}, true},
+		{QueryPredicate{"any-of?", []QueryPredicateArg{capture, str("a"), str("b")}}, false},
+		{QueryPredicate{"any-of?", []QueryPredicateArg{capture, capture}}, true},
+		{QueryPredicate{"set!", []QueryPredicateArg{str("key"), str("value")}}, false},
+	} {
+		_, err := compilePredicate(tt.p, QueryOptions{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("compilePredicate(%v) error = %v, want error %v", tt.p, err, tt.wantErr)
+		}
+	}
+}
+
+func TestQuantify(t *testing.T) {
+	for _, tt := range []struct {
+		results  []bool
+		all, any bool
+	}{
+		{nil, true, true},
+		{[]bool{true, true}, true, true},
+		{[]bool{true, false}, false, true},
+		{[]bool{false, false}, false, false},
+	} {
+		if got := quantify(tt.results, true); got != tt.all {
+			t.Errorf("quantify(%v, all) = %v, want %v", tt.results, got, tt.all)
+		}
+		if got := quantify(tt.results, false); got != tt.any {
+			t.Errorf("quantify(%v, any) = %v, want %v", tt.results, got, tt.any)
+		}
+	}
+}
@@ -6,17 +6,66 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tetratelabs/wazero/api"
 )
 
 type (
 	Query struct {
 		t Treesitter
 		q uint64
+		s *queryState
+	}
+
+	// queryState is the Go side state of a query, shared by its copies.
+	queryState struct {
+		closed bool
+		// source, language and options are the arguments of NewQuery,
+		// kept to clone the query.
+		source   string
+		language Language
+		options  QueryOptions
+		// disabledCaptures and disabledPatterns are the captures and
+		// patterns disabled so far. Patterns are filtered on the Go side
+		// when the module does not export ts_query_disable_pattern.
+		disabledCaptures []string
+		disabledPatterns map[uint32]bool
+		// predicates holds the predicates of each pattern.
+		predicates     [][]queryPredicate
+		predicateFuncs map[string]QueryPredicateFunc
 	}
 
 	QueryCursor struct {
-		t  Treesitter
-		qc uint64
+		t        Treesitter
+		qc       uint64
+		matchPtr uint64
+		s        *queryCursorState
+	}
+
+	// queryCursorState is the Go side state of a query cursor, shared by
+	// its copies.
+	queryCursorState struct {
+		closed bool
+		// query is the query last executed, and treeClosed the closed
+		// flag of the tree it runs on.
+		query      Query
+		treeClosed *bool
+		// text is the text given to ExecWithText, if hasText is set.
+		text    []byte
+		hasText bool
+		// ranges is the range set with SetByteRange and SetPointRange,
+		// and containing the one set with their containing variants.
+		ranges, containing queryRange
+		// matchLimitSet is set once SetMatchLimit was called, and
+		// exceeded once the guest reported exceeding the limit.
+		matchLimitSet bool
+		exceeded      bool
+		// timeout is the timeout set with SetTimeout, and deadline when
+		// it expires for the last Exec.
+		timeout  time.Duration
+		deadline time.Time
 	}
 
 	QueryCapture struct {
@@ -28,6 +77,10 @@ type (
 		ID           uint32
 		PatternIndex uint16
 		Captures     []QueryCapture
+		// LimitExceeded is set once the cursor has exceeded its match
+		// limit: matches may be missing from the results, including from
+		// the final result returned with false.
+		LimitExceeded bool
 	}
 )
 
@@ -41,173 +94,458 @@ const (
 	QueryErrorLanguage
 )
 
-func (t Treesitter) NewQuery(ctx context.Context, pattern string, l Language) (Query, error) {
-	errOffPtr, err := t.malloc.Call(ctx, 4)
+// QueryError is returned by NewQuery when the pattern does not compile.
+type QueryError struct {
+	// Kind is one of the QueryError constants.
+	Kind uint32
+	// Offset is the byte offset of the error in the pattern.
+	Offset uint32
+	// Row and Column are the zero-based position of Offset. Column counts
+	// bytes.
+	Row    uint32
+	Column uint32
+	// Identifier is the node type, field or capture name at Offset for
+	// errors of those kinds, if one was found.
+	Identifier string
+	// Snippet is the line of the pattern containing the error, followed by
+	// a line with a caret under Offset.
+	Snippet string
+}
+
+var queryIdentifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*`)
+
+func newQueryError(pattern string, kind, offset uint32) *QueryError {
+	off := min(int(offset), len(pattern))
+	lineStart := strings.LastIndexByte(pattern[:off], '\n') + 1
+	lineEnd := strings.IndexByte(pattern[off:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(pattern)
+	} else {
+		lineEnd += off
+	}
+
+	e := &QueryError{
+		Kind:   kind,
+		Offset: offset,
+		Row:    uint32(strings.Count(pattern[:lineStart], "\n")),
+		Column: uint32(off - lineStart),
+	}
+	switch kind {
+	case QueryErrorNodeType, QueryErrorField, QueryErrorCapture:
+		e.Identifier = queryIdentifierRegexp.FindString(pattern[off:])
+	}
+	whitespace := strings.Repeat(" ", utf8.RuneCountInString(pattern[lineStart:off]))
+	e.Snippet = pattern[lineStart:lineEnd] + "\n" + whitespace + "^"
+	return e
+}
+
+func (e *QueryError) Error() string {
+	kind := QueryErrorTypeToString(e.Kind)
+	switch {
+	case e.Identifier != "":
+		return fmt.Sprintf("invalid %s '%s' at line %d column %d",
+			kind, e.Identifier, e.Row+1, e.Column+1)
+	case e.Kind == QueryErrorNodeType || e.Kind == QueryErrorField || e.Kind == QueryErrorCapture:
+		return fmt.Sprintf("invalid %s at line %d column %d",
+			kind, e.Row+1, e.Column+1)
+	default:
+		return fmt.Sprintf("invalid %s at line %d column %d\n%s",
+			kind, e.Row+1, e.Column+1, e.Snippet)
+	}
+}
+
+// QueryOptions configures NewQuery. NewQuery accepts at most one.
+type QueryOptions struct {
+	// LuaPatterns makes #match? and its variants use Lua patterns, like
+	// #lua-match?, instead of Go regular expressions. Queries written for
+	// Neovim need it.
+	LuaPatterns bool
+}
+
+// NewQuery compiles pattern for l. A pattern that does not compile returns
+// a *QueryError.
+func (t Treesitter) NewQuery(ctx context.Context, pattern string, l Language, opts ...QueryOptions) (Query, error) {
+	if err := t.checkOpen(); err != nil {
+		return Query{}, fmt.Errorf("creating query: %w", err)
+	}
+	if err := t.checkSame(l.t); err != nil {
+		return Query{}, fmt.Errorf("creating query: %w", err)
+	}
+	if l.l == 0 {
+		return Query{}, fmt.Errorf("creating query: %w", ErrNoLanguage)
+	}
+	errOffPtr, err := t.allocate(ctx, 4)
 	if err != nil {
 		return Query{}, fmt.Errorf("allocating query error offset: %w", err)
 	}
-	errTypePtr, err := t.malloc.Call(ctx, 4)
+	defer t.free.Call(ctx, errOffPtr)
+	errTypePtr, err := t.allocate(ctx, 4)
 	if err != nil {
 		return Query{}, fmt.Errorf("allocating query error type: %w", err)
 	}
+	defer t.free.Call(ctx, errTypePtr)
 	patternPtr, patternSize, freePattern, err := t.allocateString(ctx, pattern)
 	if err != nil {
 		return Query{}, fmt.Errorf("allocating pattern string: %w", err)
 	}
 	defer freePattern()
-	queryPtr, err := t.queryNew.Call(ctx, l.l, patternPtr, patternSize, errOffPtr[0], errTypePtr[0])
+	queryPtr, err := t.queryNew.Call(ctx, l.l, patternPtr, patternSize, errOffPtr, errTypePtr)
 	if err != nil {
 		return Query{}, fmt.Errorf("creating query: %w", err)
 	}
-	errorOffset, ok := t.m.Memory().ReadUint32Le(uint32(errOffPtr[0]))
+	errorOffset, ok := t.m.Memory().ReadUint32Le(uint32(errOffPtr))
 	if !ok {
 		return Query{}, errors.New("invalid query error offset")
 	}
-	errorType, ok := t.m.Memory().ReadUint32Le(uint32(errTypePtr[0]))
+	errorType, ok := t.m.Memory().ReadUint32Le(uint32(errTypePtr))
 	if !ok {
 		return Query{}, errors.New("invalid query error type")
 	}
 
 	if errorType != QueryErrorNone {
-		// search for the line containing the offset
-		line := 1
-		line_start := 0
-		for i, c := range pattern {
-			line_start = i
-			if uint32(i) >= errorOffset {
-				break
-			}
-			if c == '\n' {
-				line++
-			}
-		}
-		column := int(errorOffset) - line_start
-		errorTypeToString := QueryErrorTypeToString(errorType)
-
-		var message string
-		switch errorType {
-		// errors that apply to a single identifier
-		case QueryErrorNodeType:
-			fallthrough
-		case QueryErrorField:
-			fallthrough
-		case QueryErrorCapture:
-			// find identifier at input[errorOffset]
-			// and report it in the error message
-			s := string(pattern[errorOffset:])
-			identifierRegexp := regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*`)
-			m := identifierRegexp.FindStringSubmatch(s)
-			if len(m) > 0 {
-				message = fmt.Sprintf("invalid %s '%s' at line %d column %d",
-					errorTypeToString, m[0], line, column)
-			} else {
-				message = fmt.Sprintf("invalid %s at line %d column %d",
-					errorTypeToString, line, column)
-			}
+		return Query{}, newQueryError(pattern, errorType, errorOffset)
+	}
 
-		// errors the report position
-		case QueryErrorSyntax:
-			fallthrough
-		case QueryErrorStructure:
-			fallthrough
-		case QueryErrorLanguage:
-			fallthrough
-		default:
-			s := string(pattern[errorOffset:])
-			lines := strings.Split(s, "\n")
-			whitespace := strings.Repeat(" ", column)
-			message = fmt.Sprintf("invalid %s at line %d column %d\n%s\n%s^",
-				errorTypeToString, line, column,
-				lines[0], whitespace)
-		}
+	var o QueryOptions
+	if len(opts) > 0 {
+		o = opts[len(opts)-1]
+	}
+	q := Query{t, queryPtr[0], &queryState{source: pattern, language: l, options: o}}
+	predicates, err := q.readPredicates(ctx, o)
+	if err != nil {
+		q.Close(ctx)
+		return Query{}, fmt.Errorf("creating query: %w", err)
+	}
+	q.s.predicates = predicates
+	return q, nil
+}
 
-		return Query{}, errors.New(message)
+// Close deletes the query. Cursors executing it return ErrClosed
+// afterwards.
+func (q Query) Close(ctx context.Context) error {
+	if q.s == nil || q.s.closed || q.t.checkOpen() != nil {
+		return nil
+	}
+	q.s.closed = true
+	_, err := q.t.queryDelete.Call(ctx, q.q)
+	if err != nil {
+		return fmt.Errorf("deleting query: %w", err)
 	}
+	return nil
+}
 
-	return Query{t, queryPtr[0]}, nil
+func (q Query) checkOpen() error {
+	if q.s == nil || q.s.closed {
+		return ErrClosed
+	}
+	return q.t.checkOpen()
 }
 
+// CaptureNameForID returns the name of the capture id, without the
+// leading "@".
 func (q Query) CaptureNameForID(ctx context.Context, id uint32) (string, error) {
-	strlenPtr, err := q.t.malloc.Call(ctx, 4)
+	if err := q.checkOpen(); err != nil {
+		return "", fmt.Errorf("getting capture name for id: %w", err)
+	}
+	name, err := q.readString(ctx, q.t.queryCaptureNameForID, id)
+	if err != nil {
+		return "", fmt.Errorf("getting capture name for id: %w", err)
+	}
+	return name, nil
+}
+
+// readString reads the string returned by fn for id, a query function
+// returning a string and writing its length, such as
+// ts_query_capture_name_for_id.
+func (q Query) readString(ctx context.Context, fn api.Function, id uint32) (string, error) {
+	strlenPtr, err := q.t.allocate(ctx, 4)
 	if err != nil {
 		return "", fmt.Errorf("allocating string length: %w", err)
 	}
-	namePtr, err := q.t.queryCaptureNameForID.Call(ctx, q.q, uint64(id), strlenPtr[0])
+	defer q.t.free.Call(ctx, strlenPtr)
+	strPtr, err := fn.Call(ctx, q.q, uint64(id), strlenPtr)
 	if err != nil {
-		return "", fmt.Errorf("getting capture name for id: %w", err)
+		return "", err
 	}
-	strlen, ok := q.t.m.Memory().ReadUint32Le(uint32(strlenPtr[0]))
+	strlen, ok := q.t.m.Memory().ReadUint32Le(uint32(strlenPtr))
 	if !ok {
 		return "", errors.New("invalid str len")
 	}
-	captureName, ok := q.t.m.Memory().Read(uint32(namePtr[0]), strlen)
+	str, ok := q.t.m.Memory().Read(uint32(strPtr[0]), strlen)
 	if !ok {
-		return "", errors.New("invalid capture name")
+		return "", errors.New("invalid string")
 	}
-	return string(captureName), nil
+	return string(str), nil
 }
 
 func (t Treesitter) NewQueryCursor(ctx context.Context) (QueryCursor, error) {
+	if err := t.checkOpen(); err != nil {
+		return QueryCursor{}, fmt.Errorf("creating query cursor: %w", err)
+	}
 	qc, err := t.queryCursorNew.Call(ctx)
 	if err != nil {
 		return QueryCursor{}, fmt.Errorf("creating query cursor: %w", err)
 	}
-	return QueryCursor{t, qc[0]}, nil
+	if qc[0] == 0 {
+		return QueryCursor{}, fmt.Errorf("creating query cursor: %w", ErrOutOfGuestMemory)
+	}
+	matchPtr, err := t.allocateQueryMatch(ctx)
+	if err != nil {
+		return QueryCursor{}, err
+	}
+	return QueryCursor{t, qc[0], matchPtr, &queryCursorState{ranges: wholeRange, containing: wholeRange}}, nil
+}
+
+// Close deletes the query cursor.
+func (qc QueryCursor) Close(ctx context.Context) error {
+	if qc.s == nil || qc.s.closed || qc.t.checkOpen() != nil {
+		return nil
+	}
+	if err := requireExport(qc.t.queryCursorDelete, "ts_query_cursor_delete"); err != nil {
+		return fmt.Errorf("deleting query cursor: %w", err)
+	}
+	qc.s.closed = true
+	qc.s.query, qc.s.text = Query{}, nil
+	_, err := qc.t.free.Call(ctx, qc.matchPtr)
+	if err != nil {
+		return fmt.Errorf("freeing query match: %w", err)
+	}
+	_, err = qc.t.queryCursorDelete.Call(ctx, qc.qc)
+	if err != nil {
+		return fmt.Errorf("deleting query cursor: %w", err)
+	}
+	return nil
 }
 
+func (qc QueryCursor) checkOpen() error {
+	if qc.s == nil || qc.s.closed {
+		return ErrClosed
+	}
+	return qc.t.checkOpen()
+}
+
+// Exec runs q on the subtree of n. Predicates comparing the text of
+// captures, such as #eq? and #match?, are ignored: use ExecWithText to
+// evaluate them.
 func (qc QueryCursor) Exec(ctx context.Context, q Query, n Node) error {
-	_, err := qc.t.queryCusorExec.Call(ctx, qc.qc, q.q, n.n)
-	return err
+	return qc.exec(ctx, q, n, nil, false)
+}
+
+// ExecWithText runs q on the subtree of n, like Exec, and makes NextMatch
+// skip the matches failing the predicates of their pattern. text is the
+// document the tree of n was parsed from; node byte offsets index it.
+func (qc QueryCursor) ExecWithText(ctx context.Context, q Query, n Node, text []byte) error {
+	return qc.exec(ctx, q, n, text, true)
+}
+
+func (qc QueryCursor) exec(ctx context.Context, q Query, n Node, text []byte, hasText bool) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("executing query: %w", err)
+	}
+	if err := q.checkOpen(); err != nil {
+		return fmt.Errorf("executing query: %w", err)
+	}
+	if err := qc.t.checkSame(q.t); err != nil {
+		return fmt.Errorf("executing query: %w", err)
+	}
+	if err := qc.t.checkSame(n.t); err != nil {
+		return fmt.Errorf("executing query: node: %w", err)
+	}
+	if err := n.checkOpen(); err != nil {
+		return fmt.Errorf("executing query: node: %w", err)
+	}
+	nodePtr, err := qc.t.writeNode(n.n)
+	if err != nil {
+		return fmt.Errorf("executing query: %w", err)
+	}
+	if err := qc.setGuestTimeout(ctx); err != nil {
+		return fmt.Errorf("executing query: %w", err)
+	}
+	_, err = qc.t.queryCusorExec.Call(ctx, qc.qc, q.q, nodePtr)
+	if err != nil {
+		return err
+	}
+	qc.s.query, qc.s.text, qc.s.hasText = q, text, hasText
+	qc.s.treeClosed = n.closed
+	qc.s.exceeded, qc.s.deadline = false, time.Time{}
+	if qc.s.timeout > 0 {
+		qc.s.deadline = time.Now().Add(qc.s.timeout)
+	}
+	return nil
 }
 
 func (t Treesitter) allocateQueryMatch(ctx context.Context) (uint64, error) {
-	// allocate tsquerymatch 12 bytes
-	nodePtr, err := t.malloc.Call(ctx, uint64(12))
+	// allocate tsquerymatch 12 bytes, followed by the capture index of
+	// ts_query_cursor_next_capture
+	nodePtr, err := t.allocate(ctx, uint64(16))
 	if err != nil {
 		return 0, fmt.Errorf("allocating query match: %w", err)
 	}
-	return nodePtr[0], nil
+	return nodePtr, nil
 }
 
+// NextMatch returns the next match satisfying the predicates of its
+// pattern, and false once there are none left.
 func (qc QueryCursor) NextMatch(ctx context.Context) (QueryMatch, bool, error) {
-	queryMatchPtr, err := qc.t.allocateQueryMatch(ctx)
-	if err != nil {
-		return QueryMatch{}, false, err
+	if err := qc.checkOpen(); err != nil {
+		return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: %w", err)
+	}
+	q := qc.s.query
+	if q.s != nil && q.s.closed {
+		return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: query: %w", ErrClosed)
 	}
-	hasNextMatch, err := qc.t.queryCursorNextMatch.Call(ctx, qc.qc, queryMatchPtr)
+	if qc.s.treeClosed != nil && *qc.s.treeClosed {
+		return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: tree: %w", ErrClosed)
+	}
+	for {
+		if err := qc.checkDeadline(); err != nil {
+			return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: %w", err)
+		}
+		m, ok, err := qc.nextMatch(ctx)
+		if err != nil {
+			return QueryMatch{}, false, err
+		}
+		m.LimitExceeded, err = qc.checkMatchLimit(ctx)
+		if err != nil {
+			return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: %w", err)
+		}
+		if !ok {
+			if err := qc.checkTimedOut(ctx); err != nil {
+				return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: %w", err)
+			}
+			return m, false, nil
+		}
+		ok, err = qc.keep(ctx, q, m)
+		if err != nil {
+			return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: %w", err)
+		}
+		if ok {
+			return m, true, nil
+		}
+	}
+}
+
+// NextCapture returns the next capture in document order with its match
+// and its index in the captures of the match, and false once there are
+// none left. Captures are ordered by start byte, as
+// ts_query_cursor_next_capture returns them. A cursor should be advanced
+// with either NextMatch or NextCapture after each Exec, not both.
+func (qc QueryCursor) NextCapture(ctx context.Context) (QueryMatch, uint32, bool, error) {
+	if err := qc.checkOpen(); err != nil {
+		return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+	}
+	q := qc.s.query
+	if q.s != nil && q.s.closed {
+		return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: query: %w", ErrClosed)
+	}
+	if qc.s.treeClosed != nil && *qc.s.treeClosed {
+		return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: tree: %w", ErrClosed)
+	}
+	if err := requireExport(qc.t.queryCursorNextCapture, "ts_query_cursor_next_capture"); err != nil {
+		return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+	}
+	for {
+		if err := qc.checkDeadline(); err != nil {
+			return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+		}
+		hasNext, err := qc.t.queryCursorNextCapture.Call(ctx, qc.qc, qc.matchPtr, qc.matchPtr+12)
+		if err != nil {
+			return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+		}
+		exceeded, err := qc.checkMatchLimit(ctx)
+		if err != nil {
+			return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+		}
+		if hasNext[0] == 0 {
+			if err := qc.checkTimedOut(ctx); err != nil {
+				return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+			}
+			return QueryMatch{LimitExceeded: exceeded}, 0, false, nil
+		}
+		m, err := qc.readMatch()
+		if err != nil {
+			return QueryMatch{}, 0, false, err
+		}
+		m.LimitExceeded = exceeded
+		index, ok := qc.t.m.Memory().ReadUint32Le(uint32(qc.matchPtr) + 12)
+		if !ok {
+			return QueryMatch{}, 0, false, errors.New("invalid capture index")
+		}
+		ok, err = qc.keep(ctx, q, m)
+		if err != nil {
+			return QueryMatch{}, 0, false, fmt.Errorf("getting query cursor next capture: %w", err)
+		}
+		if ok {
+			return m, index, true, nil
+		}
+	}
+}
+
+// keep reports whether m satisfies the predicates of q and the containing
+// range and disabled patterns filtered on the Go side.
+func (qc QueryCursor) keep(ctx context.Context, q Query, m QueryMatch) (bool, error) {
+	ok, err := qc.inContaining(ctx, m)
+	if err != nil || !ok {
+		return false, err
+	}
+	if q.s == nil {
+		return true, nil
+	}
+	if q.s.disabledPatterns[uint32(m.PatternIndex)] {
+		return false, nil
+	}
+	return q.satisfies(ctx, m, qc.s.text, qc.s.hasText)
+}
+
+// nextMatch returns the next structural match, ignoring predicates.
+func (qc QueryCursor) nextMatch(ctx context.Context) (QueryMatch, bool, error) {
+	hasNextMatch, err := qc.t.queryCursorNextMatch.Call(ctx, qc.qc, qc.matchPtr)
 	if err != nil {
 		return QueryMatch{}, false, fmt.Errorf("getting query cursor next match: %w", err)
 	}
 	if hasNextMatch[0] == 0 {
 		return QueryMatch{}, false, nil
 	}
+	m, err := qc.readMatch()
+	if err != nil {
+		return QueryMatch{}, false, err
+	}
+	return m, true, nil
+}
 
+// readMatch reads the TSQueryMatch written to the match buffer.
+func (qc QueryCursor) readMatch() (QueryMatch, error) {
+	queryMatchPtr := qc.matchPtr
 	queryMatchID, ok := qc.t.m.Memory().ReadUint32Le(uint32(queryMatchPtr))
 	if !ok {
-		return QueryMatch{}, false, errors.New("invalid query match id")
+		return QueryMatch{}, errors.New("invalid query match id")
 	}
 	queryMatchPatternIndex, ok := qc.t.m.Memory().ReadUint16Le(uint32(queryMatchPtr) + 4)
 	if !ok {
-		return QueryMatch{}, false, errors.New("invalid query match pattern index")
+		return QueryMatch{}, errors.New("invalid query match pattern index")
 	}
 	queryMatchCaptureCount, ok := qc.t.m.Memory().ReadUint16Le(uint32(queryMatchPtr) + 6)
 	if !ok {
-		return QueryMatch{}, false, errors.New("invalid query match pattern index")
+		return QueryMatch{}, errors.New("invalid query match pattern index")
 	}
 	queryMatchCapturesPtr, ok := qc.t.m.Memory().ReadUint32Le(uint32(queryMatchPtr) + 8)
 	if !ok {
-		return QueryMatch{}, false, errors.New("invalid query match captures pointer")
+		return QueryMatch{}, errors.New("invalid query match captures pointer")
 	}
 	qcs := make([]QueryCapture, queryMatchCaptureCount)
 	addr := queryMatchCapturesPtr
 	for i := range queryMatchCaptureCount {
 		captureIndex, ok := qc.t.m.Memory().ReadUint32Le(addr + 24)
 		if !ok {
-			return QueryMatch{}, false, errors.New("invalid capture index")
+			return QueryMatch{}, errors.New("invalid capture index")
+		}
+		node, err := qc.t.readNode(uint64(addr))
+		if err != nil {
+			return QueryMatch{}, fmt.Errorf("reading capture node: %w", err)
 		}
 		qcs[i] = QueryCapture{
 			ID:   captureIndex,
-			Node: newNode(qc.t, uint64(addr)),
+			Node: newNode(qc.t, qc.s.treeClosed, node),
 		}
 		addr += 28
 	}
@@ -215,7 +553,7 @@ func (qc QueryCursor) NextMatch(ctx context.Context) (QueryMatch, bool, error) {
 		ID:           queryMatchID,
 		PatternIndex: queryMatchPatternIndex,
 		Captures:     qcs,
-	}, true, nil
+	}, nil
 }
 
 func QueryErrorTypeToString(errorType uint32) string {
@@ -230,6 +568,10 @@ func QueryErrorTypeToString(errorType uint32) string {
 		return "capture"
 	case QueryErrorSyntax:
 		return "syntax"
+	case QueryErrorStructure:
+		return "structure"
+	case QueryErrorLanguage:
+		return "language"
 	default:
 		return "unknown"
 	}
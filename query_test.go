@@ -0,0 +1,206 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestQueryCursorClose(t *testing.T) {
+	ctx := context.Background()
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close(ctx)
+
+	qc, err := ts.NewQueryCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := qc.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := qc.Close(ctx); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	if _, _, err := qc.NextMatch(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("NextMatch() after Close = %v, want ErrClosed", err)
+	}
+
+	// the guest cursor is deleted: leaking it would grow the guest memory
+	mem := ts.m.Memory()
+	size := mem.Size()
+	for range 2000 {
+		qc, err := ts.NewQueryCursor(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qc.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mem.Size() != size {
+		t.Errorf("guest memory grew from %d to %d bytes", size, mem.Size())
+	}
+}
+
+func TestNewQueryError(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		pattern string
+		kind    uint32
+		offset  uint32
+		want    QueryError
+	}{
+		{
+			name:    "node type",
+			pattern: "(select_statement (nope) @x)",
+			kind:    QueryErrorNodeType,
+			offset:  19,
+			want:    QueryError{Row: 0, Column: 19, Identifier: "nope", Snippet: "(select_statement (nope) @x)\n                   ^"},
+		},
+		{
+			name:    "field on a later line",
+			pattern: "(select_statement\n  bad-field: (identifier))\n(comment)",
+			kind:    QueryErrorField,
+			offset:  20,
+			want:    QueryError{Row: 1, Column: 2, Identifier: "bad-field", Snippet: "  bad-field: (identifier))\n  ^"},
+		},
+		{
+			name:    "capture on the last line",
+			pattern: "(comment) @c\n(#eq? @d \"x\")",
+			kind:    QueryErrorCapture,
+			offset:  20,
+			want:    QueryError{Row: 1, Column: 7, Identifier: "d", Snippet: "(#eq? @d \"x\")\n       ^"},
+		},
+		{
+			name:    "syntax error has no identifier",
+			pattern: "(comment\n  (identifier) @id",
+			kind:    QueryErrorSyntax,
+			offset:  26,
+			want:    QueryError{Row: 1, Column: 17, Snippet: "  (identifier) @id\n                 ^"},
+		},
+		{
+			name:    "offset at a line break",
+			pattern: "(comment\n",
+			kind:    QueryErrorSyntax,
+			offset:  8,
+			want:    QueryError{Row: 0, Column: 8, Snippet: "(comment\n        ^"},
+		},
+		{
+			name:    "offset past the end",
+			pattern: "(comment",
+			kind:    QueryErrorSyntax,
+			offset:  100,
+			want:    QueryError{Row: 0, Column: 8, Snippet: "(comment\n        ^"},
+		},
+		{
+			name:    "caret after multibyte characters",
+			pattern: "((comment) @c (#eq? @c \"é\") (nope))",
+			kind:    QueryErrorNodeType,
+			offset:  30,
+			want:    QueryError{Row: 0, Column: 30, Identifier: "nope", Snippet: "((comment) @c (#eq? @c \"é\") (nope))\n" + strings.Repeat(" ", 29) + "^"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Kind, tt.want.Offset = tt.kind, tt.offset
+			if got := newQueryError(tt.pattern, tt.kind, tt.offset); *got != tt.want {
+				t.Errorf("newQueryError() = %#v\nwant %#v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewQueryErrorPosition(t *testing.T) {
+	ctx := context.Background()
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close(ctx)
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ts.NewQuery(ctx, "(comment) @c\n  (nope) @n", lang)
+	var qe *QueryError
+	if !errors.As(err, &qe) {
+		t.Fatalf("NewQuery() = %v, want a *QueryError", err)
+	}
+	want := QueryError{
+		Kind:       QueryErrorNodeType,
+		Offset:     16,
+		Row:        1,
+		Column:     3,
+		Identifier: "nope",
+		Snippet:    "  (nope) @n\n   ^",
+	}
+	if *qe != want {
+		t.Errorf("NewQuery() error = %#v\nwant %#v", *qe, want)
+	}
+}
+
+func TestNextCaptureOrder(t *testing.T) {
+	ctx := context.Background()
+	ts, p := newTestParser(t)
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := p.ParseString(ctx, "select a, b from t;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the select expression, its first term and its first identifier
+	// start at the same byte: tree-sitter returns the captures of the
+	// matches as they finish, outer nodes first
+	q, err := ts.NewQuery(ctx, "(identifier) @id\n(select_expression) @list\n(term) @term", lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(ctx)
+	qc, err := ts.NewQueryCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qc.Close(ctx)
+	if err := qc.Exec(ctx, q, root); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for {
+		m, i, ok, err := qc.NextCapture(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		c := m.Captures[i]
+		name, err := q.CaptureNameForID(ctx, c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, err := c.Node.StartByte(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s@%d", name, start))
+	}
+	want := []string{"list@7", "term@7", "id@7", "term@10", "id@10", "id@17"}
+	if !slices.Equal(got, want) {
+		t.Errorf("captures = %v, want %v", got, want)
+	}
+}
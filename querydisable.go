@@ -0,0 +1,83 @@
+package treesittergo
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// DisableCapture removes the capture named name, without the leading "@",
+// from the query: its nodes are no longer captured, while the patterns
+// using it still match. It affects every copy of q, but not its clones.
+func (q Query) DisableCapture(ctx context.Context, name string) error {
+	if err := q.checkOpen(); err != nil {
+		return fmt.Errorf("disabling capture: %w", err)
+	}
+	if err := requireExport(q.t.queryDisableCapture, "ts_query_disable_capture"); err != nil {
+		return fmt.Errorf("disabling capture: %w", err)
+	}
+	namePtr, nameSize, freeName, err := q.t.allocateString(ctx, name)
+	if err != nil {
+		return fmt.Errorf("disabling capture: %w", err)
+	}
+	defer freeName()
+	_, err = q.t.queryDisableCapture.Call(ctx, q.q, namePtr, nameSize)
+	if err != nil {
+		return fmt.Errorf("disabling capture: %w", err)
+	}
+	if !slices.Contains(q.s.disabledCaptures, name) {
+		q.s.disabledCaptures = append(q.s.disabledCaptures, name)
+	}
+	return nil
+}
+
+// DisablePattern removes the pattern at index from the query, so cursors
+// no longer return its matches. It affects every copy of q, but not its
+// clones.
+//
+// If the wasm module does not export ts_query_disable_pattern, cursors
+// still search the pattern and drop its matches.
+func (q Query) DisablePattern(ctx context.Context, index uint32) error {
+	if err := q.checkPattern(ctx, index); err != nil {
+		return fmt.Errorf("disabling pattern: %w", err)
+	}
+	if q.t.queryDisablePattern != nil {
+		_, err := q.t.queryDisablePattern.Call(ctx, q.q, uint64(index))
+		if err != nil {
+			return fmt.Errorf("disabling pattern: %w", err)
+		}
+	}
+	if q.s.disabledPatterns == nil {
+		q.s.disabledPatterns = map[uint32]bool{}
+	}
+	q.s.disabledPatterns[index] = true
+	return nil
+}
+
+// Clone returns an independent copy of the query, with the same disabled
+// captures and patterns and predicate functions. The query is compiled
+// again from its source, so the clone must be closed separately.
+func (q Query) Clone(ctx context.Context) (Query, error) {
+	if err := q.checkOpen(); err != nil {
+		return Query{}, fmt.Errorf("cloning query: %w", err)
+	}
+	c, err := q.t.NewQuery(ctx, q.s.source, q.s.language, q.s.options)
+	if err != nil {
+		return Query{}, fmt.Errorf("cloning query: %w", err)
+	}
+	for _, name := range q.s.disabledCaptures {
+		if err := c.DisableCapture(ctx, name); err != nil {
+			c.Close(ctx)
+			return Query{}, fmt.Errorf("cloning query: %w", err)
+		}
+	}
+	for _, index := range slices.Sorted(maps.Keys(q.s.disabledPatterns)) {
+		if err := c.DisablePattern(ctx, index); err != nil {
+			c.Close(ctx)
+			return Query{}, fmt.Errorf("cloning query: %w", err)
+		}
+	}
+	c.s.predicateFuncs = maps.Clone(q.s.predicateFuncs)
+	return c, nil
+}
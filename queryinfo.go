@@ -0,0 +1,204 @@
+package treesittergo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// Quantifier is the number of nodes a capture matches in a pattern.
+type Quantifier uint32
+
+const (
+	QuantifierZero Quantifier = iota
+	QuantifierZeroOrOne
+	QuantifierZeroOrMore
+	QuantifierOne
+	QuantifierOneOrMore
+)
+
+func (q Quantifier) String() string {
+	switch q {
+	case QuantifierZero:
+		return "zero"
+	case QuantifierZeroOrOne:
+		return "zero or one"
+	case QuantifierZeroOrMore:
+		return "zero or more"
+	case QuantifierOne:
+		return "one"
+	case QuantifierOneOrMore:
+		return "one or more"
+	default:
+		return "unknown"
+	}
+}
+
+// PatternCount returns the number of patterns of the query.
+func (q Query) PatternCount(ctx context.Context) (uint32, error) {
+	n, err := q.count(ctx, q.t.queryPatternCount, "ts_query_pattern_count")
+	if err != nil {
+		return 0, fmt.Errorf("getting query pattern count: %w", err)
+	}
+	return n, nil
+}
+
+// CaptureCount returns the number of captures of the query. Capture ids
+// range from 0 to CaptureCount - 1.
+func (q Query) CaptureCount(ctx context.Context) (uint32, error) {
+	n, err := q.count(ctx, q.t.queryCaptureCount, "ts_query_capture_count")
+	if err != nil {
+		return 0, fmt.Errorf("getting query capture count: %w", err)
+	}
+	return n, nil
+}
+
+// StringCount returns the number of string literals of the query. String
+// ids range from 0 to StringCount - 1.
+func (q Query) StringCount(ctx context.Context) (uint32, error) {
+	n, err := q.count(ctx, q.t.queryStringCount, "ts_query_string_count")
+	if err != nil {
+		return 0, fmt.Errorf("getting query string count: %w", err)
+	}
+	return n, nil
+}
+
+func (q Query) count(ctx context.Context, fn api.Function, name string) (uint32, error) {
+	if err := q.checkOpen(); err != nil {
+		return 0, err
+	}
+	if err := requireExport(fn, name); err != nil {
+		return 0, err
+	}
+	res, err := fn.Call(ctx, q.q)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(res[0]), nil
+}
+
+// StringValueForID returns the string literal id of the query, as used
+// by predicates.
+func (q Query) StringValueForID(ctx context.Context, id uint32) (string, error) {
+	n, err := q.StringCount(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting string value for id: %w", err)
+	}
+	if id >= n {
+		return "", fmt.Errorf("getting string value for id: id %d out of range", id)
+	}
+	str, err := q.readString(ctx, q.t.queryStringValueForID, id)
+	if err != nil {
+		return "", fmt.Errorf("getting string value for id: %w", err)
+	}
+	return str, nil
+}
+
+// StartByteForPattern returns the byte offset where the pattern at index
+// starts in the query source.
+func (q Query) StartByteForPattern(ctx context.Context, index uint32) (uint32, error) {
+	res, err := q.patternCall(ctx, q.t.queryStartByteForPattern, "ts_query_start_byte_for_pattern", index)
+	if err != nil {
+		return 0, fmt.Errorf("getting start byte for pattern: %w", err)
+	}
+	return uint32(res), nil
+}
+
+// EndByteForPattern returns the byte offset where the pattern at index
+// ends in the query source. Like in tree-sitter, it includes the
+// whitespace and comments following the pattern.
+func (q Query) EndByteForPattern(ctx context.Context, index uint32) (uint32, error) {
+	res, err := q.patternCall(ctx, q.t.queryEndByteForPattern, "ts_query_end_byte_for_pattern", index)
+	if err != nil {
+		return 0, fmt.Errorf("getting end byte for pattern: %w", err)
+	}
+	return uint32(res), nil
+}
+
+// IsPatternRooted reports whether the pattern at index has a single root
+// node.
+func (q Query) IsPatternRooted(ctx context.Context, index uint32) (bool, error) {
+	res, err := q.patternCall(ctx, q.t.queryIsPatternRooted, "ts_query_is_pattern_rooted", index)
+	if err != nil {
+		return false, fmt.Errorf("getting pattern is rooted: %w", err)
+	}
+	return res != 0, nil
+}
+
+// IsPatternNonLocal reports whether the pattern at index can match nodes
+// spanning several siblings, which the cursor cannot find within a byte
+// range alone.
+func (q Query) IsPatternNonLocal(ctx context.Context, index uint32) (bool, error) {
+	res, err := q.patternCall(ctx, q.t.queryIsPatternNonLocal, "ts_query_is_pattern_non_local", index)
+	if err != nil {
+		return false, fmt.Errorf("getting pattern is non local: %w", err)
+	}
+	return res != 0, nil
+}
+
+// IsPatternGuaranteedAtStep reports whether a pattern reaching the step
+// at byteOffset in the query source is certain to match.
+func (q Query) IsPatternGuaranteedAtStep(ctx context.Context, byteOffset uint32) (bool, error) {
+	if err := q.checkOpen(); err != nil {
+		return false, fmt.Errorf("getting pattern is guaranteed at step: %w", err)
+	}
+	if err := requireExport(q.t.queryIsPatternGuaranteedAtStep, "ts_query_is_pattern_guaranteed_at_step"); err != nil {
+		return false, fmt.Errorf("getting pattern is guaranteed at step: %w", err)
+	}
+	res, err := q.t.queryIsPatternGuaranteedAtStep.Call(ctx, q.q, uint64(byteOffset))
+	if err != nil {
+		return false, fmt.Errorf("getting pattern is guaranteed at step: %w", err)
+	}
+	return res[0] != 0, nil
+}
+
+// CaptureQuantifierForID returns how many nodes the capture id matches in
+// the pattern at index.
+func (q Query) CaptureQuantifierForID(ctx context.Context, index, id uint32) (Quantifier, error) {
+	if err := q.checkPattern(ctx, index); err != nil {
+		return 0, fmt.Errorf("getting capture quantifier for id: %w", err)
+	}
+	if err := requireExport(q.t.queryCaptureQuantifierForID, "ts_query_capture_quantifier_for_id"); err != nil {
+		return 0, fmt.Errorf("getting capture quantifier for id: %w", err)
+	}
+	n, err := q.CaptureCount(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("getting capture quantifier for id: %w", err)
+	}
+	if id >= n {
+		return 0, fmt.Errorf("getting capture quantifier for id: id %d out of range", id)
+	}
+	res, err := q.t.queryCaptureQuantifierForID.Call(ctx, q.q, uint64(index), uint64(id))
+	if err != nil {
+		return 0, fmt.Errorf("getting capture quantifier for id: %w", err)
+	}
+	return Quantifier(res[0]), nil
+}
+
+// patternCall calls fn, a query function taking a pattern index.
+func (q Query) patternCall(ctx context.Context, fn api.Function, name string, index uint32) (uint64, error) {
+	if err := q.checkPattern(ctx, index); err != nil {
+		return 0, err
+	}
+	if err := requireExport(fn, name); err != nil {
+		return 0, err
+	}
+	res, err := fn.Call(ctx, q.q, uint64(index))
+	if err != nil {
+		return 0, err
+	}
+	return res[0], nil
+}
+
+// checkPattern checks that the query is open and has a pattern at index.
+func (q Query) checkPattern(ctx context.Context, index uint32) error {
+	n, err := q.PatternCount(ctx)
+	if err != nil {
+		return err
+	}
+	if index >= n {
+		return fmt.Errorf("pattern index %d out of range", index)
+	}
+	return nil
+}
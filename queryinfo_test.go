@@ -0,0 +1,114 @@
+package treesittergo
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestQueryPatternInfo(t *testing.T) {
+	ctx := context.Background()
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close(ctx)
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := "(identifier)+ @ids\n; comment\n" +
+		"((term) @t . (term)? @u)\n" +
+		"([(literal) (comment)] @x (#eq? @x \"a\"))\n" +
+		"(relation (object_reference) @r)"
+	q, err := ts.NewQuery(ctx, source, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(ctx)
+
+	// the quantifiers are those of the captures ids, t, u, x and r
+	for i, want := range []struct {
+		text             string
+		rooted, nonLocal bool
+		quantifiers      []Quantifier
+	}{
+		{
+			"(identifier)+ @ids\n; comment\n", false, true,
+			[]Quantifier{QuantifierOneOrMore, QuantifierZero, QuantifierZero, QuantifierZero, QuantifierZero},
+		},
+		{
+			"((term) @t . (term)? @u)\n", false, true,
+			[]Quantifier{QuantifierZero, QuantifierOne, QuantifierZeroOrOne, QuantifierZero, QuantifierZero},
+		},
+		{
+			"([(literal) (comment)] @x (#eq? @x \"a\"))\n", true, false,
+			[]Quantifier{QuantifierZero, QuantifierZero, QuantifierZero, QuantifierOne, QuantifierZero},
+		},
+		{
+			"(relation (object_reference) @r)", true, false,
+			[]Quantifier{QuantifierZero, QuantifierZero, QuantifierZero, QuantifierZero, QuantifierOne},
+		},
+	} {
+		index := uint32(i)
+		start, err := q.StartByteForPattern(ctx, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, err := q.EndByteForPattern(ctx, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start > end || end > uint32(len(source)) || source[start:end] != want.text {
+			t.Errorf("pattern %d spans %d to %d, want %q", i, start, end, want.text)
+		}
+		rooted, err := q.IsPatternRooted(ctx, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rooted != want.rooted {
+			t.Errorf("IsPatternRooted(%d) = %v, want %v", i, rooted, want.rooted)
+		}
+		nonLocal, err := q.IsPatternNonLocal(ctx, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonLocal != want.nonLocal {
+			t.Errorf("IsPatternNonLocal(%d) = %v, want %v", i, nonLocal, want.nonLocal)
+		}
+		var quantifiers []Quantifier
+		for id := range uint32(len(want.quantifiers)) {
+			quantifier, err := q.CaptureQuantifierForID(ctx, index, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			quantifiers = append(quantifiers, quantifier)
+		}
+		if !slices.Equal(quantifiers, want.quantifiers) {
+			t.Errorf("pattern %d capture quantifiers = %v, want %v", i, quantifiers, want.quantifiers)
+		}
+	}
+	if _, err := q.StartByteForPattern(ctx, 4); err == nil {
+		t.Error("StartByteForPattern(4) succeeded")
+	}
+
+	// a match reaching the predicate of the third pattern has all its nodes
+	for _, tt := range []struct {
+		step string
+		want bool
+	}{
+		{"(literal)", false},
+		{"(#eq?", true},
+		{"(object_reference)", false},
+	} {
+		offset := uint32(strings.Index(source, tt.step))
+		got, err := q.IsPatternGuaranteedAtStep(ctx, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("IsPatternGuaranteedAtStep(%d) at %s = %v, want %v", offset, tt.step, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueryTimeout is returned by NextMatch and NextCapture when the
+// timeout set with QueryCursor.SetTimeout expires.
+var ErrQueryTimeout = errors.New("query timed out")
+
+// SetMatchLimit caps the number of in-progress matches the cursor keeps.
+// Past it, the oldest in-progress matches are dropped, and the results are
+// flagged with QueryMatch.LimitExceeded.
+func (qc QueryCursor) SetMatchLimit(ctx context.Context, limit uint32) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("setting query cursor match limit: %w", err)
+	}
+	if err := requireExport(qc.t.queryCursorSetMatchLimit, "ts_query_cursor_set_match_limit"); err != nil {
+		return fmt.Errorf("setting query cursor match limit: %w", err)
+	}
+	_, err := qc.t.queryCursorSetMatchLimit.Call(ctx, qc.qc, uint64(limit))
+	if err != nil {
+		return fmt.Errorf("setting query cursor match limit: %w", err)
+	}
+	qc.s.matchLimitSet = true
+	return nil
+}
+
+// MatchLimit returns the limit set with SetMatchLimit.
+func (qc QueryCursor) MatchLimit(ctx context.Context) (uint32, error) {
+	if err := qc.checkOpen(); err != nil {
+		return 0, fmt.Errorf("getting query cursor match limit: %w", err)
+	}
+	if err := requireExport(qc.t.queryCursorMatchLimit, "ts_query_cursor_match_limit"); err != nil {
+		return 0, fmt.Errorf("getting query cursor match limit: %w", err)
+	}
+	res, err := qc.t.queryCursorMatchLimit.Call(ctx, qc.qc)
+	if err != nil {
+		return 0, fmt.Errorf("getting query cursor match limit: %w", err)
+	}
+	return uint32(res[0]), nil
+}
+
+// DidExceedMatchLimit reports whether the last Exec dropped in-progress
+// matches because of the match limit.
+func (qc QueryCursor) DidExceedMatchLimit(ctx context.Context) (bool, error) {
+	if err := qc.checkOpen(); err != nil {
+		return false, fmt.Errorf("getting query cursor exceeded match limit: %w", err)
+	}
+	if err := requireExport(qc.t.queryCursorDidExceedMatchLimit, "ts_query_cursor_did_exceed_match_limit"); err != nil {
+		return false, fmt.Errorf("getting query cursor exceeded match limit: %w", err)
+	}
+	res, err := qc.t.queryCursorDidExceedMatchLimit.Call(ctx, qc.qc)
+	if err != nil {
+		return false, fmt.Errorf("getting query cursor exceeded match limit: %w", err)
+	}
+	return res[0] != 0, nil
+}
+
+// SetMaxStartDepth makes the cursor only start matches at nodes at most
+// depth levels below the node given to Exec.
+func (qc QueryCursor) SetMaxStartDepth(ctx context.Context, depth uint32) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("setting query cursor max start depth: %w", err)
+	}
+	if err := requireExport(qc.t.queryCursorSetMaxStartDepth, "ts_query_cursor_set_max_start_depth"); err != nil {
+		return fmt.Errorf("setting query cursor max start depth: %w", err)
+	}
+	_, err := qc.t.queryCursorSetMaxStartDepth.Call(ctx, qc.qc, uint64(depth))
+	if err != nil {
+		return fmt.Errorf("setting query cursor max start depth: %w", err)
+	}
+	return nil
+}
+
+// SetTimeout sets how long iterating the results of an Exec may take
+// before NextMatch and NextCapture return ErrQueryTimeout. Zero means no
+// timeout. It applies to the next Exec, and also stops the cursor while it
+// searches the tree for a match.
+func (qc QueryCursor) SetTimeout(timeout time.Duration) {
+	if qc.s == nil {
+		return
+	}
+	qc.s.timeout = max(timeout, 0)
+}
+
+// Timeout returns the timeout set with SetTimeout.
+func (qc QueryCursor) Timeout() time.Duration {
+	if qc.s == nil {
+		return 0
+	}
+	return qc.s.timeout
+}
+
+// setGuestTimeout sets the timeout of the guest cursor, which stops
+// ts_query_cursor_next_match and ts_query_cursor_next_capture.
+func (qc QueryCursor) setGuestTimeout(ctx context.Context) error {
+	if err := requireExport(qc.t.queryCursorSetTimeoutMicros, "ts_query_cursor_set_timeout_micros"); err != nil {
+		return err
+	}
+	_, err := qc.t.queryCursorSetTimeoutMicros.Call(ctx, qc.qc, uint64(qc.s.timeout.Microseconds()))
+	if err != nil {
+		return fmt.Errorf("setting query cursor timeout: %w", err)
+	}
+	return nil
+}
+
+// checkTimedOut returns ErrQueryTimeout when the guest cursor stopped on
+// its timeout rather than at the end of the results.
+func (qc QueryCursor) checkTimedOut(ctx context.Context) error {
+	if qc.s.timeout == 0 {
+		return nil
+	}
+	if err := requireExport(qc.t.queryCursorDidTimeOut, "ts_query_cursor_did_time_out"); err != nil {
+		return err
+	}
+	res, err := qc.t.queryCursorDidTimeOut.Call(ctx, qc.qc)
+	if err != nil {
+		return fmt.Errorf("calling ts_query_cursor_did_time_out: %w", err)
+	}
+	if res[0] != 0 {
+		return ErrQueryTimeout
+	}
+	return nil
+}
+
+// checkDeadline returns ErrQueryTimeout once the timeout of the last Exec
+// has expired.
+func (qc QueryCursor) checkDeadline() error {
+	if !qc.s.deadline.IsZero() && time.Now().After(qc.s.deadline) {
+		return ErrQueryTimeout
+	}
+	return nil
+}
+
+// checkMatchLimit records whether the match limit was exceeded. It only
+// asks the guest once a limit was set.
+func (qc QueryCursor) checkMatchLimit(ctx context.Context) (bool, error) {
+	if !qc.s.matchLimitSet || qc.s.exceeded {
+		return qc.s.exceeded, nil
+	}
+	exceeded, err := qc.DidExceedMatchLimit(ctx)
+	if err != nil {
+		return false, err
+	}
+	qc.s.exceeded = exceeded
+	return exceeded, nil
+}
@@ -0,0 +1,152 @@
+package treesittergo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// execTestQuery parses src, and returns a cursor executing pattern on the
+// root node of the tree after set configured it.
+func execTestQuery(t *testing.T, src, pattern string, set func(QueryCursor) error) QueryCursor {
+	t.Helper()
+	ctx := context.Background()
+	ts, p := newTestParser(t)
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := p.ParseString(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close(ctx) })
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := ts.NewQuery(ctx, pattern, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close(ctx) })
+	qc, err := ts.NewQueryCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { qc.Close(ctx) })
+	if err := set(qc); err != nil {
+		t.Fatal(err)
+	}
+	if err := qc.Exec(ctx, q, root); err != nil {
+		t.Fatal(err)
+	}
+	return qc
+}
+
+func TestQueryCursorMatchLimit(t *testing.T) {
+	ctx := context.Background()
+	// every node starts a match of the pattern, and they all stay in
+	// progress until their children are seen
+	for _, tt := range []struct {
+		limit    uint32
+		matches  int
+		exceeded bool
+	}{
+		{1, 3, true},
+		{1000, 10, false},
+	} {
+		qc := execTestQuery(t, "select a, b, c from t where d = e;", "(_ (_) @a (_) @b)", func(qc QueryCursor) error {
+			return qc.SetMatchLimit(ctx, tt.limit)
+		})
+		limit, err := qc.MatchLimit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit != tt.limit {
+			t.Errorf("MatchLimit() = %d, want %d", limit, tt.limit)
+		}
+		var matches int
+		for {
+			m, ok, err := qc.NextMatch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.LimitExceeded != tt.exceeded {
+				t.Errorf("limit %d: LimitExceeded = %v, want %v", tt.limit, m.LimitExceeded, tt.exceeded)
+			}
+			if !ok {
+				break
+			}
+			matches++
+		}
+		if matches != tt.matches {
+			t.Errorf("limit %d: %d matches, want %d", tt.limit, matches, tt.matches)
+		}
+		exceeded, err := qc.DidExceedMatchLimit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exceeded != tt.exceeded {
+			t.Errorf("limit %d: DidExceedMatchLimit() = %v, want %v", tt.limit, exceeded, tt.exceeded)
+		}
+	}
+}
+
+func TestQueryCursorTimeout(t *testing.T) {
+	ctx := context.Background()
+	// nothing matches, so the first NextMatch searches the whole tree
+	src := strings.Repeat("select a, b from t;\n", 5000)
+	for _, tt := range []struct {
+		timeout time.Duration
+		want    error
+	}{
+		{0, nil},
+		{time.Millisecond, ErrQueryTimeout},
+	} {
+		qc := execTestQuery(t, src, "(keyword_where) @w", func(qc QueryCursor) error {
+			qc.SetTimeout(tt.timeout)
+			return nil
+		})
+		if _, ok, err := qc.NextMatch(ctx); ok || !errors.Is(err, tt.want) {
+			t.Errorf("timeout %v: NextMatch() = %v, %v, want false, %v", tt.timeout, ok, err, tt.want)
+		}
+	}
+}
+
+func TestQueryCursorMaxStartDepth(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		depth uint32
+		want  []string
+	}{
+		{0, []string{"program"}},
+		{1, []string{"program", "statement"}},
+		{2, []string{"program", "statement", "select", "from"}},
+	} {
+		qc := execTestQuery(t, "select a from t;", "(_) @n", func(qc QueryCursor) error {
+			return qc.SetMaxStartDepth(ctx, tt.depth)
+		})
+		var got []string
+		for {
+			m, ok, err := qc.NextMatch(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			kind, err := m.Captures[0].Node.Kind(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, kind)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("depth %d: matched %v, want %v", tt.depth, got, tt.want)
+		}
+	}
+}
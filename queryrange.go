@@ -0,0 +1,198 @@
+package treesittergo
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// queryRange is a range of a query cursor, in bytes and in points.
+type queryRange struct {
+	start, end           uint32
+	startPoint, endPoint Point
+}
+
+// wholeRange is the range of a cursor without ranges, the one tree-sitter
+// sets for an end of zero.
+var wholeRange = queryRange{end: math.MaxUint32, endPoint: Point{math.MaxUint32, math.MaxUint32}}
+
+// SetByteRange makes the cursor only search the nodes intersecting the
+// bytes from start to end, so the rest of the tree is skipped. An end of
+// zero means the end of the document. It applies to the next Exec.
+func (qc QueryCursor) SetByteRange(ctx context.Context, start, end uint32) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("setting query cursor byte range: %w", err)
+	}
+	r, err := setBytes(qc.s.ranges, start, end)
+	if err != nil {
+		return fmt.Errorf("setting query cursor byte range: %w", err)
+	}
+	qc.s.ranges = r
+	return qc.setRanges(ctx)
+}
+
+// SetPointRange makes the cursor only search the nodes intersecting the
+// range from start to end, like SetByteRange. A node must intersect both
+// the byte and the point range.
+func (qc QueryCursor) SetPointRange(ctx context.Context, start, end Point) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("setting query cursor point range: %w", err)
+	}
+	r, err := setPoints(qc.s.ranges, start, end)
+	if err != nil {
+		return fmt.Errorf("setting query cursor point range: %w", err)
+	}
+	qc.s.ranges = r
+	return qc.setRanges(ctx)
+}
+
+// SetContainingByteRange makes the cursor only return the matches whose
+// captures are all inside the bytes from start to end, like SetByteRange.
+//
+// tree-sitter 0.22 has no containing ranges: the cursor searches the
+// nodes intersecting the range, and NextMatch and NextCapture skip the
+// matches with a capture outside of it.
+func (qc QueryCursor) SetContainingByteRange(ctx context.Context, start, end uint32) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("setting query cursor containing byte range: %w", err)
+	}
+	r, err := setBytes(qc.s.containing, start, end)
+	if err != nil {
+		return fmt.Errorf("setting query cursor containing byte range: %w", err)
+	}
+	qc.s.containing = r
+	return qc.setRanges(ctx)
+}
+
+// SetContainingPointRange makes the cursor only return the matches whose
+// captures are all inside the range from start to end, like
+// SetContainingByteRange.
+func (qc QueryCursor) SetContainingPointRange(ctx context.Context, start, end Point) error {
+	if err := qc.checkOpen(); err != nil {
+		return fmt.Errorf("setting query cursor containing point range: %w", err)
+	}
+	r, err := setPoints(qc.s.containing, start, end)
+	if err != nil {
+		return fmt.Errorf("setting query cursor containing point range: %w", err)
+	}
+	qc.s.containing = r
+	return qc.setRanges(ctx)
+}
+
+func setBytes(r queryRange, start, end uint32) (queryRange, error) {
+	if end == 0 {
+		end = wholeRange.end
+	}
+	if start > end {
+		return r, errors.New("start after end")
+	}
+	r.start, r.end = start, end
+	return r, nil
+}
+
+func setPoints(r queryRange, start, end Point) (queryRange, error) {
+	if end == (Point{}) {
+		end = wholeRange.endPoint
+	}
+	if comparePoints(start, end) > 0 {
+		return r, errors.New("start after end")
+	}
+	r.startPoint, r.endPoint = start, end
+	return r, nil
+}
+
+// setRanges sets the guest range of the cursor to the intersection of its
+// range and its containing range: a match inside the containing range has
+// all its nodes intersecting it.
+func (qc QueryCursor) setRanges(ctx context.Context) error {
+	if err := requireExport(qc.t.queryCursorSetByteRange, "ts_query_cursor_set_byte_range"); err != nil {
+		return err
+	}
+	if err := requireExport(qc.t.queryCursorSetPointRange, "ts_query_cursor_set_point_range"); err != nil {
+		return err
+	}
+	r, c := qc.s.ranges, qc.s.containing
+	_, err := qc.t.queryCursorSetByteRange.Call(ctx, qc.qc, uint64(max(r.start, c.start)), uint64(min(r.end, c.end)))
+	if err != nil {
+		return fmt.Errorf("calling ts_query_cursor_set_byte_range: %w", err)
+	}
+
+	// TSPoint arguments are passed by pointer
+	points := make([]byte, 16)
+	putPoint(points, maxPoint(r.startPoint, c.startPoint))
+	putPoint(points[8:], minPoint(r.endPoint, c.endPoint))
+	ptr, _, free, err := qc.t.allocateBytes(ctx, points)
+	if err != nil {
+		return err
+	}
+	defer free()
+	_, err = qc.t.queryCursorSetPointRange.Call(ctx, qc.qc, ptr, ptr+8)
+	if err != nil {
+		return fmt.Errorf("calling ts_query_cursor_set_point_range: %w", err)
+	}
+	return nil
+}
+
+// inContaining reports whether the captures of m are all inside the
+// containing range of the cursor.
+func (qc QueryCursor) inContaining(ctx context.Context, m QueryMatch) (bool, error) {
+	c := qc.s.containing
+	if c == wholeRange {
+		return true, nil
+	}
+	for _, capture := range m.Captures {
+		r, err := capture.Node.queryRange(ctx)
+		if err != nil {
+			return false, err
+		}
+		if r.start < c.start || r.end > c.end ||
+			comparePoints(r.startPoint, c.startPoint) < 0 || comparePoints(r.endPoint, c.endPoint) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// queryRange returns the bytes and points n spans.
+func (n Node) queryRange(ctx context.Context) (queryRange, error) {
+	start, err := n.StartByte(ctx)
+	if err != nil {
+		return queryRange{}, err
+	}
+	end, err := n.EndByte(ctx)
+	if err != nil {
+		return queryRange{}, err
+	}
+	startPoint, err := n.point(ctx, n.t.nodeStartPoint)
+	if err != nil {
+		return queryRange{}, fmt.Errorf("getting node start point: %w", err)
+	}
+	endPoint, err := n.point(ctx, n.t.nodeEndPoint)
+	if err != nil {
+		return queryRange{}, fmt.Errorf("getting node end point: %w", err)
+	}
+	return queryRange{uint32(start), uint32(end), startPoint, endPoint}, nil
+}
+
+func comparePoints(a, b Point) int {
+	if a.Row != b.Row {
+		return cmp.Compare(a.Row, b.Row)
+	}
+	return cmp.Compare(a.Column, b.Column)
+}
+
+func maxPoint(a, b Point) Point {
+	if comparePoints(a, b) < 0 {
+		return b
+	}
+	return a
+}
+
+func minPoint(a, b Point) Point {
+	if comparePoints(a, b) > 0 {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,133 @@
+package treesittergo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestComparePoints(t *testing.T) {
+	for _, tt := range []struct {
+		a, b Point
+		want int
+	}{
+		{Point{1, 2}, Point{1, 2}, 0},
+		{Point{1, 2}, Point{1, 3}, -1},
+		{Point{2, 0}, Point{1, 9}, 1},
+	} {
+		if got := comparePoints(tt.a, tt.b); got != tt.want {
+			t.Errorf("comparePoints(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestQueryCursorRanges(t *testing.T) {
+	ctx := context.Background()
+	ts, p := newTestParser(t)
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identifiers at bytes 7, 10 and 17, then 27, 34 and 42 on row 1
+	src := "select a, b from t;\nselect c from u where d = 1;\n"
+	tree, err := p.ParseString(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := ts.NewQuery(ctx, "(identifier) @id\n(select_expression) @list", lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close(ctx)
+
+	for _, tt := range []struct {
+		name string
+		set  func(QueryCursor) error
+		want []string
+	}{
+		{"none", func(QueryCursor) error { return nil },
+			[]string{"id@7", "list@7", "id@10", "id@17", "id@27", "list@27", "id@34", "id@42"}},
+		{"bytes", func(qc QueryCursor) error { return qc.SetByteRange(ctx, 8, 17) },
+			[]string{"list@7", "id@10"}},
+		{"bytes to the end", func(qc QueryCursor) error { return qc.SetByteRange(ctx, 30, 0) },
+			[]string{"id@34", "id@42"}},
+		{"points without text", func(qc QueryCursor) error { return qc.SetPointRange(ctx, Point{1, 0}, Point{1, 10}) },
+			[]string{"id@27", "list@27"}},
+		// a node must intersect both ranges
+		{"bytes and points", func(qc QueryCursor) error {
+			if err := qc.SetByteRange(ctx, 0, 20); err != nil {
+				return err
+			}
+			return qc.SetPointRange(ctx, Point{0, 9}, Point{2, 0})
+		}, []string{"list@7", "id@10", "id@17"}},
+		{"containing bytes", func(qc QueryCursor) error { return qc.SetContainingByteRange(ctx, 7, 10) },
+			[]string{"id@7"}},
+		{"containing points", func(qc QueryCursor) error {
+			return qc.SetContainingPointRange(ctx, Point{1, 7}, Point{1, 16})
+		}, []string{"id@27", "list@27", "id@34"}},
+		{"intersecting and containing", func(qc QueryCursor) error {
+			if err := qc.SetByteRange(ctx, 9, 40); err != nil {
+				return err
+			}
+			return qc.SetContainingByteRange(ctx, 0, 28)
+		}, []string{"list@7", "id@10", "id@17", "id@27", "list@27"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			qc, err := ts.NewQueryCursor(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer qc.Close(ctx)
+			if err := tt.set(qc); err != nil {
+				t.Fatal(err)
+			}
+			if err := qc.Exec(ctx, q, root); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for {
+				m, ok, err := qc.NextMatch(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				for _, c := range m.Captures {
+					name, err := q.CaptureNameForID(ctx, c.ID)
+					if err != nil {
+						t.Fatal(err)
+					}
+					start, err := c.Node.StartByte(ctx)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, fmt.Sprintf("%s@%d", name, start))
+				}
+			}
+			slices.Sort(got)
+			slices.Sort(tt.want)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("captures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	qc, err := ts.NewQueryCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qc.Close(ctx)
+	if err := qc.SetByteRange(ctx, 5, 4); err == nil {
+		t.Error("SetByteRange with start after end succeeded")
+	}
+	if err := qc.SetPointRange(ctx, Point{1, 0}, Point{0, 5}); err == nil {
+		t.Error("SetPointRange with start after end succeeded")
+	}
+}
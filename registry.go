@@ -0,0 +1,190 @@
+package treesittergo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownLanguage is returned when no registered language matches a
+// name or a file.
+var ErrUnknownLanguage = errors.New("unknown language")
+
+// LanguageSpec describes a language of the registry: how to recognize its
+// files and how to load it into a Treesitter.
+type LanguageSpec struct {
+	// Name is the unique name of the language, such as "sql".
+	Name string
+	// Aliases are other names LookupLanguage accepts.
+	Aliases []string
+	// Extensions are file extensions including the leading dot, such as
+	// ".sql".
+	Extensions []string
+	// Filenames are base names matched exactly, for files without a
+	// meaningful extension.
+	Filenames []string
+	// Interpreters are the programs named by a "#!" line, such as "psql".
+	Interpreters []string
+	// Detect reports whether content looks like the language. It is only
+	// consulted for files without an extension when no file name or
+	// interpreter matches.
+	Detect func(content []byte) bool
+	// Load returns the language in t.
+	Load func(ctx context.Context, t Treesitter) (Language, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []LanguageSpec
+)
+
+// RegisterLanguage adds spec to the registry. It panics if spec has no
+// name or loader, or if its name or an alias is already registered.
+func RegisterLanguage(spec LanguageSpec) {
+	if spec.Name == "" || spec.Load == nil {
+		panic("treesittergo: RegisterLanguage needs a name and a loader")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, name := range append([]string{spec.Name}, spec.Aliases...) {
+		if _, ok := lookupLanguage(name); ok {
+			panic("treesittergo: RegisterLanguage called twice for " + name)
+		}
+	}
+	registry = append(registry, spec)
+}
+
+// LookupLanguage returns the language registered with name, or an alias
+// of it. Names are compared case-insensitively.
+func LookupLanguage(name string) (LanguageSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return lookupLanguage(name)
+}
+
+func lookupLanguage(name string) (LanguageSpec, bool) {
+	for _, spec := range registry {
+		if strings.EqualFold(spec.Name, name) || containsFold(spec.Aliases, name) {
+			return spec, true
+		}
+	}
+	return LanguageSpec{}, false
+}
+
+// DetectLanguage returns the language of a file, trying in order its base
+// name, its extension, the interpreter of a "#!" first line of content,
+// and, if the file name has no extension, the content heuristics of the
+// languages in registration order.
+func DetectLanguage(filename string, content []byte) (LanguageSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if filename != "" {
+		base := filepath.Base(filename)
+		for _, spec := range registry {
+			if containsFold(spec.Filenames, base) {
+				return spec, true
+			}
+		}
+		if ext := filepath.Ext(base); ext != "" {
+			for _, spec := range registry {
+				if containsFold(spec.Extensions, ext) {
+					return spec, true
+				}
+			}
+		}
+	}
+
+	if interpreter := shebangInterpreter(content); interpreter != "" {
+		for _, spec := range registry {
+			if containsFold(spec.Interpreters, interpreter) {
+				return spec, true
+			}
+		}
+	}
+
+	if filename != "" && filepath.Ext(filepath.Base(filename)) != "" {
+		return LanguageSpec{}, false
+	}
+	for _, spec := range registry {
+		if spec.Detect != nil && spec.Detect(content) {
+			return spec, true
+		}
+	}
+	return LanguageSpec{}, false
+}
+
+// shebangInterpreter returns the program named by the "#!" first line of
+// content, looking through "env", or "" if there is none.
+func shebangInterpreter(content []byte) string {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return ""
+	}
+	line, _, _ := bytes.Cut(content[2:], []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				return filepath.Base(f)
+			}
+		}
+		return ""
+	}
+	return interpreter
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// LanguageByName loads the language registered with name, or an alias of
+// it.
+func (t Treesitter) LanguageByName(ctx context.Context, name string) (Language, error) {
+	spec, ok := LookupLanguage(name)
+	if !ok {
+		return Language{}, fmt.Errorf("loading language %s: %w", name, ErrUnknownLanguage)
+	}
+	return spec.Load(ctx, t)
+}
+
+// ParseFile detects the language of a file with DetectLanguage and parses
+// content with it.
+func (t Treesitter) ParseFile(ctx context.Context, filename string, content []byte) (Tree, LanguageSpec, error) {
+	spec, ok := DetectLanguage(filename, content)
+	if !ok {
+		return Tree{}, LanguageSpec{}, fmt.Errorf("parsing %s: %w", filename, ErrUnknownLanguage)
+	}
+
+	l, err := spec.Load(ctx, t)
+	if err != nil {
+		return Tree{}, spec, fmt.Errorf("parsing %s: loading %s: %w", filename, spec.Name, err)
+	}
+	p, err := t.NewParser(ctx)
+	if err != nil {
+		return Tree{}, spec, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	defer p.Close(ctx)
+	if err := p.SetLanguage(ctx, l); err != nil {
+		return Tree{}, spec, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+
+	tree, err := p.ParseString(ctx, string(content))
+	if err != nil {
+		return Tree{}, spec, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	return tree, spec, nil
+}
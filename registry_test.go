@@ -0,0 +1,28 @@
+package treesittergo
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tt := range []struct {
+		filename string
+		content  string
+		want     string
+	}{
+		{"schema.sql", "", "sql"},
+		{"dump.PGSQL", "", "sql"},
+		{"migrate", "#!/usr/bin/env psql\nselect 1;", "sql"},
+		{"query", "-- users\nSELECT * FROM users;", "sql"},
+		{"query", "/* setup */\ncreate table t (id int);", "sql"},
+		{"", "  update t set a = 1 where id = 2;", "sql"},
+		{"notes.txt", "Update the changelog;", ""},
+		{"NOTES", "Update the changelog before the release.", ""},
+		{"NOTES", "Select one of the options", ""},
+		{"query", "select;", ""},
+		{"main.go", "package main", ""},
+	} {
+		spec, ok := DetectLanguage(tt.filename, []byte(tt.content))
+		if ok != (tt.want != "") || spec.Name != tt.want {
+			t.Errorf("DetectLanguage(%q, %q) = %q, %v, want %q", tt.filename, tt.content, spec.Name, ok, tt.want)
+		}
+	}
+}
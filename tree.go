@@ -2,28 +2,222 @@ package treesittergo
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 )
 
-type Tree struct {
-	ts Treesitter
-	t  uint64
+type (
+	Tree struct {
+		ts Treesitter
+		t  uint64
+		s  *treeState
+	}
+
+	// treeState is the state shared by the copies of a Tree.
+	treeState struct {
+		closed bool
+		// includedRanges are the included ranges of the parser when the
+		// tree was parsed, nil for the whole document.
+		includedRanges []Range
+	}
+
+	Point struct {
+		Row    uint32
+		Column uint32
+	}
+
+	// Range is a span of source text with both byte and point bounds.
+	Range struct {
+		StartPoint Point
+		EndPoint   Point
+		StartByte  uint32
+		EndByte    uint32
+	}
+
+	// InputEdit describes a change to the source text of a tree. Byte offsets
+	// and points refer to the text before the edit for StartByte and
+	// OldEndByte, and to the text after the edit for NewEndByte.
+	InputEdit struct {
+		StartByte   uint32
+		OldEndByte  uint32
+		NewEndByte  uint32
+		StartPoint  Point
+		OldEndPoint Point
+		NewEndPoint Point
+	}
+)
+
+// ErrNullTree is returned when tree-sitter fails to produce a tree for
+// another reason than a timeout or a cancellation.
+var ErrNullTree = errors.New("parser returned a null tree")
+
+func newTree(ts Treesitter, t uint64, includedRanges []Range) Tree {
+	return Tree{ts: ts, t: t, s: &treeState{includedRanges: includedRanges}}
+}
+
+// Close deletes the tree. Nodes obtained from it return ErrClosed
+// afterwards.
+func (t Tree) Close(ctx context.Context) error {
+	if t.s == nil || t.s.closed || t.ts.checkOpen() != nil {
+		return nil
+	}
+	t.s.closed = true
+	_, err := t.ts.treeDelete.Call(ctx, t.t)
+	if err != nil {
+		return fmt.Errorf("deleting tree: %w", err)
+	}
+	return nil
 }
 
-func newTree(ts Treesitter, t uint64) Tree {
-	return Tree{ts, t}
+func (t Tree) checkOpen() error {
+	if t.s == nil || t.s.closed {
+		return ErrClosed
+	}
+	return t.ts.checkOpen()
 }
 
 func (t Tree) RootNode(ctx context.Context) (Node, error) {
-	// allocate tsnode 24 bytes
-	nodePtr, err := t.ts.malloc.Call(ctx, uint64(24))
+	if err := t.checkOpen(); err != nil {
+		return Node{}, fmt.Errorf("getting tree root node: %w", err)
+	}
+	_, err := t.ts.treeRootNode.Call(ctx, t.ts.resultNodePtr(), t.t)
 	if err != nil {
-		return Node{}, fmt.Errorf("allocating node: %w", err)
+		return Node{}, fmt.Errorf("getting tree root node: %w", err)
 	}
-
-	_, err = t.ts.treeRootNode.Call(ctx, nodePtr[0], t.t)
+	root, err := t.ts.readNode(t.ts.resultNodePtr())
 	if err != nil {
 		return Node{}, fmt.Errorf("getting tree root node: %w", err)
 	}
-	return newNode(t.ts, nodePtr[0]), nil
+	return newNode(t.ts, &t.s.closed, root), nil
+}
+
+// Edit adjusts the tree to match an edit of its source text, so it can be
+// passed as the old tree to Parser.ParseStringWithOldTree.
+func (t Tree) Edit(ctx context.Context, edit InputEdit) error {
+	if err := t.checkOpen(); err != nil {
+		return fmt.Errorf("editing tree: %w", err)
+	}
+	if err := requireExport(t.ts.treeEdit, "ts_tree_edit"); err != nil {
+		return fmt.Errorf("editing tree: %w", err)
+	}
+
+	// allocate tsinputedit 36 bytes
+	editBytes := make([]byte, 36)
+	binary.LittleEndian.PutUint32(editBytes[0:], edit.StartByte)
+	binary.LittleEndian.PutUint32(editBytes[4:], edit.OldEndByte)
+	binary.LittleEndian.PutUint32(editBytes[8:], edit.NewEndByte)
+	putPoint(editBytes[12:], edit.StartPoint)
+	putPoint(editBytes[20:], edit.OldEndPoint)
+	putPoint(editBytes[28:], edit.NewEndPoint)
+	editPtr, _, freeEdit, err := t.ts.allocateBytes(ctx, editBytes)
+	if err != nil {
+		return fmt.Errorf("allocating input edit: %w", err)
+	}
+	defer freeEdit()
+
+	_, err = t.ts.treeEdit.Call(ctx, t.t, editPtr)
+	if err != nil {
+		return fmt.Errorf("editing tree: %w", err)
+	}
+	return nil
+}
+
+// ChangedRanges compares t, an edited old tree, with other, the tree
+// produced by reparsing it, and returns the ranges whose syntactic structure
+// changed.
+func (t Tree) ChangedRanges(ctx context.Context, other Tree) ([]Range, error) {
+	if err := t.checkOpen(); err != nil {
+		return nil, fmt.Errorf("getting changed ranges: %w", err)
+	}
+	if err := other.checkOpen(); err != nil {
+		return nil, fmt.Errorf("getting changed ranges: other tree: %w", err)
+	}
+	if err := t.ts.checkSame(other.ts); err != nil {
+		return nil, fmt.Errorf("getting changed ranges: other tree: %w", err)
+	}
+	if err := requireExport(t.ts.treeGetChangedRanges, "ts_tree_get_changed_ranges"); err != nil {
+		return nil, fmt.Errorf("getting changed ranges: %w", err)
+	}
+
+	lengthPtr, err := t.ts.allocate(ctx, 4)
+	if err != nil {
+		return nil, fmt.Errorf("allocating ranges length: %w", err)
+	}
+	defer t.ts.free.Call(ctx, lengthPtr)
+
+	rangesPtr, err := t.ts.treeGetChangedRanges.Call(ctx, t.t, other.t, lengthPtr)
+	if err != nil {
+		return nil, fmt.Errorf("getting changed ranges: %w", err)
+	}
+	defer t.ts.free.Call(ctx, rangesPtr[0])
+
+	length, ok := t.ts.m.Memory().ReadUint32Le(uint32(lengthPtr))
+	if !ok {
+		return nil, errors.New("invalid ranges length")
+	}
+	return t.ts.readRanges(rangesPtr[0], length)
+}
+
+func (t Treesitter) readRanges(ptr uint64, length uint32) ([]Range, error) {
+	// tsrange 24 bytes
+	b, ok := t.m.Memory().Read(uint32(ptr), length*24)
+	if !ok {
+		return nil, errors.New("invalid ranges")
+	}
+	ranges := make([]Range, length)
+	for i := range ranges {
+		r := b[i*24:]
+		ranges[i] = Range{
+			StartPoint: readPoint(r[0:]),
+			EndPoint:   readPoint(r[8:]),
+			StartByte:  binary.LittleEndian.Uint32(r[16:]),
+			EndByte:    binary.LittleEndian.Uint32(r[20:]),
+		}
+	}
+	return ranges, nil
+}
+
+// IncludedRanges returns the included ranges the tree was parsed with. See
+// Parser.SetIncludedRanges.
+func (t Tree) IncludedRanges() []Range {
+	if t.s == nil {
+		return includedRangesOrAll(nil)
+	}
+	return includedRangesOrAll(t.s.includedRanges)
+}
+
+// includedRangesOrAll returns ranges, or the range tree-sitter uses when no
+// ranges are set if ranges is nil.
+func includedRangesOrAll(ranges []Range) []Range {
+	if ranges == nil {
+		return []Range{{
+			EndPoint: Point{Row: math.MaxUint32, Column: math.MaxUint32},
+			EndByte:  math.MaxUint32,
+		}}
+	}
+	return slices.Clone(ranges)
+}
+
+func appendRange(b []byte, r Range) []byte {
+	b = binary.LittleEndian.AppendUint32(b, r.StartPoint.Row)
+	b = binary.LittleEndian.AppendUint32(b, r.StartPoint.Column)
+	b = binary.LittleEndian.AppendUint32(b, r.EndPoint.Row)
+	b = binary.LittleEndian.AppendUint32(b, r.EndPoint.Column)
+	b = binary.LittleEndian.AppendUint32(b, r.StartByte)
+	return binary.LittleEndian.AppendUint32(b, r.EndByte)
+}
+
+func readPoint(b []byte) Point {
+	return Point{
+		Row:    binary.LittleEndian.Uint32(b[0:]),
+		Column: binary.LittleEndian.Uint32(b[4:]),
+	}
+}
+
+func putPoint(b []byte, p Point) {
+	binary.LittleEndian.PutUint32(b[0:], p.Row)
+	binary.LittleEndian.PutUint32(b[4:], p.Column)
 }
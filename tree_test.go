@@ -0,0 +1,169 @@
+package treesittergo
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTreeComparable(t *testing.T) {
+	a := newTree(Treesitter{}, 1, []Range{{StartByte: 2, EndByte: 4}})
+	b := a
+	trees := map[Tree]bool{a: true}
+	if !trees[b] {
+		t.Error("copy of a tree is not equal to it")
+	}
+	if a == newTree(Treesitter{}, 1, nil) {
+		t.Error("distinct trees are equal")
+	}
+	if got := b.IncludedRanges(); !slices.Equal(got, []Range{{StartByte: 2, EndByte: 4}}) {
+		t.Errorf("IncludedRanges() = %v", got)
+	}
+	if got := (Tree{}).IncludedRanges(); !slices.Equal(got, includedRangesOrAll(nil)) {
+		t.Errorf("zero Tree IncludedRanges() = %v", got)
+	}
+}
+
+// newTestParser returns a parser for the SQL language of a new Treesitter,
+// closed with the test.
+func newTestParser(t *testing.T) (Treesitter, Parser) {
+	t.Helper()
+	ctx := context.Background()
+	ts, err := New(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ts.Close(ctx) })
+	lang, err := ts.LanguageSQL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ts.NewParser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetLanguage(ctx, lang); err != nil {
+		t.Fatal(err)
+	}
+	return ts, p
+}
+
+func treeString(t *testing.T, tree Tree) string {
+	t.Helper()
+	ctx := context.Background()
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := root.String(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEditReparse(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	tree, err := p.ParseString(ctx, "select a from t;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+
+	// "select a from t;" -> "select a, b from t;"
+	err = tree.Edit(ctx, InputEdit{
+		StartByte:   8,
+		OldEndByte:  8,
+		NewEndByte:  11,
+		StartPoint:  Point{Column: 8},
+		OldEndPoint: Point{Column: 8},
+		NewEndPoint: Point{Column: 11},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.RootNode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end, err := root.EndByte(ctx); err != nil || end != 19 {
+		t.Errorf("edited tree EndByte() = %d, %v, want 19", end, err)
+	}
+
+	text := "select a, b from t;"
+	reparsed, err := p.ParseStringWithOldTree(ctx, tree, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reparsed.Close(ctx)
+	fresh, err := p.ParseString(ctx, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close(ctx)
+
+	got, want := treeString(t, reparsed), treeString(t, fresh)
+	if got != want {
+		t.Errorf("reparsed tree = %s, want %s", got, want)
+	}
+	if strings.Count(got, "(field name: (identifier))") != 2 {
+		t.Errorf("reparsed tree = %s, want two fields", got)
+	}
+}
+
+func TestChangedRanges(t *testing.T) {
+	ctx := context.Background()
+	_, p := newTestParser(t)
+
+	tree, err := p.ParseString(ctx, "select a from t;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close(ctx)
+	// "select a from t;" -> "select a from t where b;"
+	err = tree.Edit(ctx, InputEdit{
+		StartByte:   15,
+		OldEndByte:  15,
+		NewEndByte:  23,
+		StartPoint:  Point{Column: 15},
+		OldEndPoint: Point{Column: 15},
+		NewEndPoint: Point{Column: 23},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := p.ParseStringWithOldTree(ctx, tree, "select a from t where b;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reparsed.Close(ctx)
+
+	ranges, err := tree.ChangedRanges(ctx, reparsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Range{{
+		StartPoint: Point{Column: 15},
+		EndPoint:   Point{Column: 23},
+		StartByte:  15,
+		EndByte:    23,
+	}}
+	if !slices.Equal(ranges, want) {
+		t.Errorf("ChangedRanges() = %+v, want %+v", ranges, want)
+	}
+
+	// the returned array is freed: leaking it would grow the guest memory
+	mem := p.t.m.Memory()
+	size := mem.Size()
+	for range 5000 {
+		if _, err := tree.ChangedRanges(ctx, reparsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mem.Size() != size {
+		t.Errorf("guest memory grew from %d to %d bytes", size, mem.Size())
+	}
+}
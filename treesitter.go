@@ -5,38 +5,111 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
-	"github.com/tetratelabs/wazero/imports/emscripten"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
 //go:embed ts-combined-sql.wasm
 var tsWasm []byte
 
+// ErrNotExported is returned when the wasm module does not export the
+// tree-sitter function backing the called method.
+var ErrNotExported = errors.New("function not exported by wasm module")
+
+// ErrClosed is returned when a method is called on an object, or on an
+// object created from a Treesitter, that has already been closed.
+var ErrClosed = errors.New("use of closed object")
+
+// ErrInstanceMismatch is returned when an object created from one
+// Treesitter instance is passed to an object of another instance.
+var ErrInstanceMismatch = errors.New("object belongs to a different treesitter instance")
+
+// ErrOutOfGuestMemory is returned when an allocation in the wasm module
+// fails.
+var ErrOutOfGuestMemory = errors.New("out of guest memory")
+
 type Treesitter struct {
 	m api.Module
+	// r is the runtime owned by t, nil when t was acquired from a Pool or
+	// created in a runtime given with WithRuntime.
+	r      wazero.Runtime
+	closed *atomic.Bool
+	stderr *switchWriter
+
+	// nodePtr is a 48 byte scratch area holding a TSNode argument followed
+	// by a TSNode result, so node calls do not allocate.
+	nodePtr uint64
+
+	// linked holds the grammars LoadLanguage linked into m.
+	linked *linked
+
+	*exports
+}
 
-	malloc api.Function
-	free   api.Function
-	strlen api.Function
+// exports are the functions exported by the module of a Treesitter, held
+// by pointer so that the Treesitter copied into every Node stays small.
+type exports struct {
+	malloc  api.Function
+	realloc api.Function
+	free    api.Function
+	strlen  api.Function
 
-	parserNew         api.Function
-	parserParseString api.Function
-	parserDelete      api.Function
-	parserSetLanguage api.Function
+	parserNew               api.Function
+	parserParse             api.Function
+	parserParseString       api.Function
+	parserDelete            api.Function
+	parserSetLanguage       api.Function
+	parserSetIncludedRanges api.Function
+	parserSetLogger         api.Function
+	parserPrintDotGraphs    api.Function
+	parserSetTimeoutMicros  api.Function
+	parserReset             api.Function
+	parserHasOutstanding    api.Function
+	parserCurrentByteOffset api.Function
 
 	languageName    api.Function
 	languageVersion api.Function
 
-	treeRootNode api.Function
+	treeRootNode         api.Function
+	treeDelete           api.Function
+	treeEdit             api.Function
+	treeGetChangedRanges api.Function
+
+	queryNew                  api.Function
+	queryDelete               api.Function
+	queryPatternCount         api.Function
+	queryCaptureCount         api.Function
+	queryStringCount          api.Function
+	queryStringValueForID     api.Function
+	queryPredicatesForPattern api.Function
+	queryCaptureNameForID     api.Function
+	queryDisableCapture       api.Function
+	queryDisablePattern       api.Function
+
+	queryStartByteForPattern       api.Function
+	queryEndByteForPattern         api.Function
+	queryIsPatternRooted           api.Function
+	queryIsPatternNonLocal         api.Function
+	queryIsPatternGuaranteedAtStep api.Function
+	queryCaptureQuantifierForID    api.Function
+
+	queryCursorNew         api.Function
+	queryCursorDelete      api.Function
+	queryCusorExec         api.Function
+	queryCursorNextMatch   api.Function
+	queryCursorNextCapture api.Function
 
-	queryNew              api.Function
-	queryCursorNew        api.Function
-	queryCusorExec        api.Function
-	queryCursorNextMatch  api.Function
-	queryCaptureNameForID api.Function
+	queryCursorSetByteRange        api.Function
+	queryCursorSetPointRange       api.Function
+	queryCursorSetMatchLimit       api.Function
+	queryCursorMatchLimit          api.Function
+	queryCursorDidExceedMatchLimit api.Function
+	queryCursorSetMaxStartDepth    api.Function
+	queryCursorSetTimeoutMicros    api.Function
+	queryCursorDidTimeOut          api.Function
 
 	nodeString          api.Function
 	nodeChildCount      api.Function
@@ -46,78 +119,237 @@ type Treesitter struct {
 	nodeType            api.Function
 	nodeEndByte         api.Function
 	nodeStartByte       api.Function
+	nodeStartPoint      api.Function
+	nodeEndPoint        api.Function
 	nodeIsError         api.Function
 
 	languageSQL api.Function
+	inputInit   api.Function
+	loggerInit  api.Function
 }
 
-func New(ctx context.Context) (Treesitter, error) {
-	r := wazero.NewRuntime(ctx)
+func New(ctx context.Context, opts ...Option) (Treesitter, error) {
+	c, err := newConfig(opts)
+	if err != nil {
+		return Treesitter{}, err
+	}
+	r, owned := c.newRuntime(ctx)
+	closeRuntime := func() {
+		if owned {
+			r.Close(ctx)
+		}
+	}
 
-	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	compiled, err := compile(ctx, r)
+	if err != nil {
+		closeRuntime()
+		return Treesitter{}, err
+	}
 
-	compiled, err := r.CompileModule(ctx, tsWasm)
+	t, err := instantiate(ctx, r, compiled, c)
 	if err != nil {
-		return Treesitter{}, fmt.Errorf("compiling wasm module: %w", err)
+		closeRuntime()
+		return Treesitter{}, err
+	}
+	if owned {
+		t.r = r
 	}
+	return t, nil
+}
 
-	_, err = emscripten.InstantiateForModule(ctx, r, compiled)
+// compile instantiates the host modules imported by the embedded wasm
+// module and by grammar side modules into r, unless a previous call
+// already did, and compiles it.
+func compile(ctx context.Context, r wazero.Runtime) (wazero.CompiledModule, error) {
+	if r.Module(wasi_snapshot_preview1.ModuleName) == nil {
+		_, err := wasi_snapshot_preview1.Instantiate(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("instantiating wasi module: %w", err)
+		}
+	}
+	if r.Module(hostModuleName) == nil {
+		if err := instantiateHost(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+
+	compiled, err := r.CompileModule(ctx, tsWasm)
 	if err != nil {
-		return Treesitter{}, fmt.Errorf("instantiating emscripten module: %w", err)
+		return nil, fmt.Errorf("compiling wasm module: %w", err)
 	}
+	return compiled, nil
+}
 
-	mod, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig())
+// instantiate creates a new anonymous instance of compiled, with its own
+// linear memory.
+func instantiate(
+	ctx context.Context,
+	r wazero.Runtime,
+	compiled wazero.CompiledModule,
+	c config,
+) (Treesitter, error) {
+	stderr := &switchWriter{base: c.stderr}
+	mod, err := r.InstantiateModule(ctx, compiled, c.moduleConfig(stderr))
 	if err != nil {
 		return Treesitter{}, fmt.Errorf("instantiating module: %w", err)
 	}
 
-	return Treesitter{
-		m:                     mod,
-		malloc:                mod.ExportedFunction("malloc"),
-		free:                  mod.ExportedFunction("free"),
-		strlen:                mod.ExportedFunction("strlen"),
-		parserNew:             mod.ExportedFunction("ts_parser_new"),
-		parserParseString:     mod.ExportedFunction("ts_parser_parse_string"),
-		parserSetLanguage:     mod.ExportedFunction("ts_parser_set_language"),
-		parserDelete:          mod.ExportedFunction("ts_parser_delete"),
-		queryNew:              mod.ExportedFunction("ts_query_new"),
-		queryCursorNew:        mod.ExportedFunction("ts_query_cursor_new"),
-		queryCusorExec:        mod.ExportedFunction("ts_query_cursor_exec"),
-		queryCursorNextMatch:  mod.ExportedFunction("ts_query_cursor_next_match"),
-		queryCaptureNameForID: mod.ExportedFunction("ts_query_capture_name_for_id"),
-		languageName:          mod.ExportedFunction("ts_language_name"),
-		languageVersion:       mod.ExportedFunction("ts_language_version"),
-		treeRootNode:          mod.ExportedFunction("ts_tree_root_node"),
-		nodeString:            mod.ExportedFunction("ts_node_string"),
-		nodeChildCount:        mod.ExportedFunction("ts_node_child_count"),
-		nodeNamedChildCount:   mod.ExportedFunction("ts_node_named_child_count"),
-		nodeChild:             mod.ExportedFunction("ts_node_child"),
-		nodeNamedChild:        mod.ExportedFunction("ts_node_named_child"),
-		nodeType:              mod.ExportedFunction("ts_node_type"),
-		nodeStartByte:         mod.ExportedFunction("ts_node_start_byte"),
-		nodeEndByte:           mod.ExportedFunction("ts_node_end_byte"),
-		nodeIsError:           mod.ExportedFunction("ts_node_is_error"),
-		languageSQL:           mod.ExportedFunction("tree_sitter_sql"),
-	}, nil
+	t := Treesitter{
+		m:      mod,
+		stderr: stderr,
+		closed: new(atomic.Bool),
+		linked: &linked{r: r, languages: map[string]uint64{}},
+		exports: &exports{
+			malloc:                         mod.ExportedFunction("malloc"),
+			realloc:                        mod.ExportedFunction("realloc"),
+			free:                           mod.ExportedFunction("free"),
+			strlen:                         mod.ExportedFunction("strlen"),
+			parserNew:                      mod.ExportedFunction("ts_parser_new"),
+			parserParse:                    mod.ExportedFunction("ts_parser_parse"),
+			parserParseString:              mod.ExportedFunction("ts_parser_parse_string"),
+			parserSetLanguage:              mod.ExportedFunction("ts_parser_set_language"),
+			parserDelete:                   mod.ExportedFunction("ts_parser_delete"),
+			parserSetIncludedRanges:        mod.ExportedFunction("ts_parser_set_included_ranges"),
+			parserSetLogger:                mod.ExportedFunction("ts_parser_set_logger"),
+			parserPrintDotGraphs:           mod.ExportedFunction("ts_parser_print_dot_graphs"),
+			parserSetTimeoutMicros:         mod.ExportedFunction("ts_parser_set_timeout_micros"),
+			parserReset:                    mod.ExportedFunction("ts_parser_reset"),
+			parserHasOutstanding:           mod.ExportedFunction("tsg_parser_has_outstanding_parse"),
+			parserCurrentByteOffset:        mod.ExportedFunction("tsg_parser_current_byte_offset"),
+			queryNew:                       mod.ExportedFunction("ts_query_new"),
+			queryDelete:                    mod.ExportedFunction("ts_query_delete"),
+			queryPatternCount:              mod.ExportedFunction("ts_query_pattern_count"),
+			queryCaptureCount:              mod.ExportedFunction("ts_query_capture_count"),
+			queryStringCount:               mod.ExportedFunction("ts_query_string_count"),
+			queryStringValueForID:          mod.ExportedFunction("ts_query_string_value_for_id"),
+			queryPredicatesForPattern:      mod.ExportedFunction("ts_query_predicates_for_pattern"),
+			queryStartByteForPattern:       mod.ExportedFunction("ts_query_start_byte_for_pattern"),
+			queryEndByteForPattern:         mod.ExportedFunction("ts_query_end_byte_for_pattern"),
+			queryIsPatternRooted:           mod.ExportedFunction("ts_query_is_pattern_rooted"),
+			queryIsPatternNonLocal:         mod.ExportedFunction("ts_query_is_pattern_non_local"),
+			queryIsPatternGuaranteedAtStep: mod.ExportedFunction("ts_query_is_pattern_guaranteed_at_step"),
+			queryCaptureQuantifierForID:    mod.ExportedFunction("ts_query_capture_quantifier_for_id"),
+			queryDisableCapture:            mod.ExportedFunction("ts_query_disable_capture"),
+			queryDisablePattern:            mod.ExportedFunction("ts_query_disable_pattern"),
+			queryCursorNew:                 mod.ExportedFunction("ts_query_cursor_new"),
+			queryCursorDelete:              mod.ExportedFunction("ts_query_cursor_delete"),
+			queryCusorExec:                 mod.ExportedFunction("ts_query_cursor_exec"),
+			queryCursorNextMatch:           mod.ExportedFunction("ts_query_cursor_next_match"),
+			queryCursorNextCapture:         mod.ExportedFunction("ts_query_cursor_next_capture"),
+			queryCursorSetByteRange:        mod.ExportedFunction("ts_query_cursor_set_byte_range"),
+			queryCursorSetPointRange:       mod.ExportedFunction("ts_query_cursor_set_point_range"),
+			queryCursorSetMatchLimit:       mod.ExportedFunction("ts_query_cursor_set_match_limit"),
+			queryCursorMatchLimit:          mod.ExportedFunction("ts_query_cursor_match_limit"),
+			queryCursorDidExceedMatchLimit: mod.ExportedFunction("ts_query_cursor_did_exceed_match_limit"),
+			queryCursorSetMaxStartDepth:    mod.ExportedFunction("ts_query_cursor_set_max_start_depth"),
+			queryCursorSetTimeoutMicros:    mod.ExportedFunction("ts_query_cursor_set_timeout_micros"),
+			queryCursorDidTimeOut:          mod.ExportedFunction("ts_query_cursor_did_time_out"),
+			queryCaptureNameForID:          mod.ExportedFunction("ts_query_capture_name_for_id"),
+			languageName:                   mod.ExportedFunction("ts_language_name"),
+			languageVersion:                mod.ExportedFunction("ts_language_version"),
+			treeRootNode:                   mod.ExportedFunction("ts_tree_root_node"),
+			treeDelete:                     mod.ExportedFunction("ts_tree_delete"),
+			treeEdit:                       mod.ExportedFunction("ts_tree_edit"),
+			treeGetChangedRanges:           mod.ExportedFunction("ts_tree_get_changed_ranges"),
+			nodeString:                     mod.ExportedFunction("ts_node_string"),
+			nodeChildCount:                 mod.ExportedFunction("ts_node_child_count"),
+			nodeNamedChildCount:            mod.ExportedFunction("ts_node_named_child_count"),
+			nodeChild:                      mod.ExportedFunction("ts_node_child"),
+			nodeNamedChild:                 mod.ExportedFunction("ts_node_named_child"),
+			nodeType:                       mod.ExportedFunction("ts_node_type"),
+			nodeStartByte:                  mod.ExportedFunction("ts_node_start_byte"),
+			nodeEndByte:                    mod.ExportedFunction("ts_node_end_byte"),
+			nodeStartPoint:                 mod.ExportedFunction("ts_node_start_point"),
+			nodeEndPoint:                   mod.ExportedFunction("ts_node_end_point"),
+			nodeIsError:                    mod.ExportedFunction("ts_node_is_error"),
+			languageSQL:                    mod.ExportedFunction("tree_sitter_sql"),
+			inputInit:                      mod.ExportedFunction("tsg_input_init"),
+			loggerInit:                     mod.ExportedFunction("tsg_logger_init"),
+		},
+	}
+
+	nodePtr, err := t.allocate(ctx, 48)
+	if err != nil {
+		mod.Close(ctx)
+		return Treesitter{}, fmt.Errorf("allocating node scratch: %w", err)
+	}
+	t.nodePtr = nodePtr
+
+	return t, nil
+}
+
+// Close releases the wasm runtime, or only the module instance when t does
+// not own its runtime. Every object created from t becomes unusable and
+// returns ErrClosed afterwards.
+func (t Treesitter) Close(ctx context.Context) error {
+	if !t.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if t.r == nil {
+		t.linked.close(ctx)
+		if err := t.m.Close(ctx); err != nil {
+			return fmt.Errorf("closing module: %w", err)
+		}
+		return nil
+	}
+	if err := t.r.Close(ctx); err != nil {
+		return fmt.Errorf("closing runtime: %w", err)
+	}
+	return nil
+}
+
+func (t Treesitter) checkOpen() error {
+	if t.closed == nil || t.closed.Load() || t.m.IsClosed() {
+		return ErrClosed
+	}
+	return nil
+}
+
+// checkSame returns ErrInstanceMismatch unless o is the same instance as t.
+func (t Treesitter) checkSame(o Treesitter) error {
+	if t.m != o.m {
+		return ErrInstanceMismatch
+	}
+	return nil
+}
+
+// allocate mallocs size bytes in guest memory.
+func (t Treesitter) allocate(ctx context.Context, size uint64) (uint64, error) {
+	// malloc may return NULL for a zero size
+	ptr, err := t.malloc.Call(ctx, max(size, 1))
+	if err != nil {
+		return 0, err
+	}
+	if ptr[0] == 0 {
+		return 0, fmt.Errorf("allocating %d bytes: %w", size, ErrOutOfGuestMemory)
+	}
+	return ptr[0], nil
 }
 
 func (t Treesitter) allocateString(
 	ctx context.Context,
 	str string,
 ) (ptr uint64, size uint64, free func(), err error) {
-	strByte := []byte(str)
-	strSize := uint64(len(strByte))
-	strPtr, err := t.malloc.Call(ctx, strSize)
+	return t.allocateBytes(ctx, []byte(str))
+}
+
+func (t Treesitter) allocateBytes(
+	ctx context.Context,
+	b []byte,
+) (ptr uint64, size uint64, free func(), err error) {
+	bSize := uint64(len(b))
+	bPtr, err := t.allocate(ctx, bSize)
 	if err != nil {
 		return 0, 0, nil, fmt.Errorf("allocating string: %w", err)
 	}
 
-	if !t.m.Memory().Write(uint32(strPtr[0]), strByte) {
-		return 0, 0, nil, fmt.Errorf("writing string: %w", err)
+	if !t.m.Memory().Write(uint32(bPtr), b) {
+		t.free.Call(ctx, bPtr)
+		return 0, 0, nil, fmt.Errorf("writing string: %d bytes at %d out of range", bSize, bPtr)
 	}
 
-	return strPtr[0], strSize, func() {
-		t.free.Call(context.Background(), strPtr[0])
+	return bPtr, bSize, func() {
+		t.free.Call(context.Background(), bPtr)
 	}, nil
 }
 
@@ -132,3 +364,10 @@ func (t Treesitter) readString(ctx context.Context, ptr uint64) (string, error)
 	}
 	return string(strBytes), nil
 }
+
+func requireExport(fn api.Function, name string) error {
+	if fn == nil {
+		return fmt.Errorf("%s: %w", name, ErrNotExported)
+	}
+	return nil
+}
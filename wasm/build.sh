@@ -0,0 +1,54 @@
+#!/bin/sh
+# build.sh rebuilds ../ts-combined-sql.wasm from the tree-sitter runtime and
+# SQL grammar sources vendored by github.com/smacker/go-tree-sitter.
+#
+# It needs clang and wasm-ld with the WebAssembly target, found through the
+# CLANG and WASM_LD variables. No emscripten or wasi-libc is needed: include/
+# and libc.c provide the few C library functions the runtime calls.
+set -eu
+cd "$(dirname "$0")"
+
+CLANG=${CLANG:-clang}
+WASM_LD=${WASM_LD:-wasm-ld}
+SOURCES=github.com/smacker/go-tree-sitter@v0.0.0-20240827094217-dd81d9e9be82
+
+src=$(go mod download -json "$SOURCES" | sed -n 's/^[[:space:]]*"Dir": "\(.*\)",$/\1/p')
+build=$(mktemp -d)
+trap 'rm -rf "$build"' EXIT
+
+# Bulk memory is left out: wazero's compiler backend faults on the
+# memory.copy and memory.fill code clang emits for the runtime.
+cflags="--target=wasm32 -O2 -nostdinc -isystem include -DNDEBUG -msign-ext -mmutable-globals"
+"$CLANG" $cflags -fno-builtin -fno-strict-aliasing -c libc.c -o "$build/libc.o"
+# query-timeout.patch backports the query cursor timeout of later
+# tree-sitter versions, so a timeout also stops the search for a match, and
+# query-end-byte.patch backports ts_query_end_byte_for_pattern.
+cp "$src/query.c" "$build/query.c"
+chmod u+w "$build/query.c"
+patch -s "$build/query.c" query-timeout.patch
+patch -s "$build/query.c" query-end-byte.patch
+"$CLANG" $cflags -I"$build" -I"$src" -c runtime.c -o "$build/runtime.o"
+"$CLANG" $cflags -I"$src" -c sql.c -o "$build/sql.o"
+
+# Every function of the public API is exported, except the wasm store ones,
+# which need a wasm engine inside the module, plus the functions of the patch
+# and the bindings of runtime.c.
+# Grammar side modules loaded by LoadLanguage import the stack pointer, the
+# function table and the C library functions tree-sitter offers external
+# scanners.
+exports=$(grep -oE '\bts_[a-z0-9_]+\(' "$src/api.h" | tr -d '(' | grep -v '^ts_wasm_' | sort -u)
+exports="$exports
+	ts_query_cursor_set_timeout_micros ts_query_cursor_timeout_micros
+	ts_query_cursor_did_time_out ts_query_end_byte_for_pattern
+	tsg_input_init tsg_logger_init tsg_parser_has_outstanding_parse
+	tsg_parser_current_byte_offset
+	tree_sitter_sql __stack_pointer
+	calloc free iswalnum iswalpha iswblank iswdigit iswlower iswspace iswupper
+	iswxdigit malloc memchr memcmp memcpy memmove memset realloc strcmp strlen
+	strncat strncmp strncpy towlower towupper"
+
+"$WASM_LD" --no-entry --stack-first -z stack-size=65536 \
+	--export-table --growable-table \
+	$(printf -- '--export=%s ' $exports) \
+	-o ../ts-combined-sql.wasm \
+	"$build/runtime.o" "$build/sql.o" "$build/libc.o"
@@ -0,0 +1,33 @@
+#!/bin/sh
+# grammar.sh builds a grammar side module for Treesitter.LoadLanguage, like
+# `tree-sitter build --wasm` does, from a grammar directory vendored by
+# github.com/smacker/go-tree-sitter:
+#
+#	sh grammar.sh dockerfile ../testdata/tree-sitter-dockerfile.wasm
+#
+# The third argument names the language function when it differs from the
+# directory, such as go for golang.
+set -eu
+dir=$1
+out=$(cd "$(dirname "$2")" && pwd)/$(basename "$2")
+name=${3:-$1}
+cd "$(dirname "$0")"
+
+CLANG=${CLANG:-clang}
+WASM_LD=${WASM_LD:-wasm-ld}
+SOURCES=github.com/smacker/go-tree-sitter@v0.0.0-20240827094217-dd81d9e9be82
+
+src=$(go mod download -json "$SOURCES" | sed -n 's/^[[:space:]]*"Dir": "\(.*\)",$/\1/p')
+build=$(mktemp -d)
+trap 'rm -rf "$build"' EXIT
+
+echo "#include \"$dir/parser.c\"" >"$build/$name.c"
+if [ -f "$src/$dir/scanner.c" ]; then
+	echo "#include \"$dir/scanner.c\"" >>"$build/$name.c"
+fi
+
+# Side modules are position independent: their data is placed at
+# __memory_base and their functions at __table_base when they are loaded.
+cflags="--target=wasm32-unknown-emscripten -fPIC -fvisibility=hidden -O2 -nostdinc -isystem include -DNDEBUG -msign-ext -mmutable-globals"
+"$CLANG" $cflags -I"$src" -c "$build/$name.c" -o "$build/$name.o"
+"$WASM_LD" -shared --experimental-pic --export=tree_sitter_$name -o "$out" "$build/$name.o"
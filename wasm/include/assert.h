@@ -0,0 +1,7 @@
+#undef assert
+
+#ifdef NDEBUG
+#define assert(e) ((void)0)
+#else
+#define assert(e) ((e) ? (void)0 : __builtin_trap())
+#endif
@@ -0,0 +1,23 @@
+#ifndef _LIMITS_H
+#define _LIMITS_H
+
+#define CHAR_BIT 8
+#define SCHAR_MIN (-128)
+#define SCHAR_MAX 127
+#define UCHAR_MAX 255
+#define CHAR_MIN SCHAR_MIN
+#define CHAR_MAX SCHAR_MAX
+#define SHRT_MIN (-1 - SHRT_MAX)
+#define SHRT_MAX __SHRT_MAX__
+#define USHRT_MAX 0xffff
+#define INT_MIN (-1 - INT_MAX)
+#define INT_MAX __INT_MAX__
+#define UINT_MAX 0xffffffffU
+#define LONG_MIN (-1L - LONG_MAX)
+#define LONG_MAX __LONG_MAX__
+#define ULONG_MAX (2UL * LONG_MAX + 1UL)
+#define LLONG_MIN (-1LL - LLONG_MAX)
+#define LLONG_MAX __LONG_LONG_MAX__
+#define ULLONG_MAX (2ULL * LLONG_MAX + 1ULL)
+
+#endif
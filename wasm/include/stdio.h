@@ -0,0 +1,30 @@
+#ifndef _STDIO_H
+#define _STDIO_H
+
+#include <stdarg.h>
+#include <stddef.h>
+
+typedef struct FILE FILE;
+
+extern FILE *const stdout;
+extern FILE *const stderr;
+
+#define EOF (-1)
+
+FILE *fdopen(int fd, const char *mode);
+int fclose(FILE *f);
+int fflush(FILE *f);
+size_t fwrite(const void *restrict buf, size_t size, size_t count, FILE *restrict f);
+int fputc(int c, FILE *f);
+int fputs(const char *restrict s, FILE *restrict f);
+int putc(int c, FILE *f);
+int putchar(int c);
+
+int printf(const char *restrict format, ...);
+int fprintf(FILE *restrict f, const char *restrict format, ...);
+int vfprintf(FILE *restrict f, const char *restrict format, va_list ap);
+int sprintf(char *restrict buf, const char *restrict format, ...);
+int snprintf(char *restrict buf, size_t size, const char *restrict format, ...);
+int vsnprintf(char *restrict buf, size_t size, const char *restrict format, va_list ap);
+
+#endif
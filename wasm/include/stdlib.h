@@ -0,0 +1,16 @@
+#ifndef _STDLIB_H
+#define _STDLIB_H
+
+#include <stddef.h>
+
+void *malloc(size_t size);
+void *calloc(size_t count, size_t size);
+void *realloc(void *ptr, size_t size);
+void free(void *ptr);
+
+_Noreturn void abort(void);
+
+int abs(int x);
+long labs(long x);
+
+#endif
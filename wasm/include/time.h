@@ -0,0 +1,22 @@
+#ifndef _TIME_H
+#define _TIME_H
+
+#include <stdint.h>
+
+typedef int64_t time_t;
+typedef int64_t clock_t;
+typedef int clockid_t;
+
+struct timespec {
+  time_t tv_sec;
+  long tv_nsec;
+};
+
+#define CLOCKS_PER_SEC ((clock_t)1000000)
+#define CLOCK_REALTIME 0
+#define CLOCK_MONOTONIC 1
+
+int clock_gettime(clockid_t id, struct timespec *ts);
+clock_t clock(void);
+
+#endif
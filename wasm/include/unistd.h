@@ -0,0 +1,6 @@
+#ifndef _UNISTD_H
+#define _UNISTD_H
+
+int dup(int fd);
+
+#endif
@@ -0,0 +1,643 @@
+// libc.c is the small C library the tree-sitter runtime and grammars are
+// linked against. It covers only what they call: a size-class allocator
+// over linear memory, string and character helpers, a printf family that
+// writes through WASI fd_write, and clocks backed by WASI clock_time_get.
+
+#include <ctype.h>
+#include <limits.h>
+#include <stdarg.h>
+#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+#include <unistd.h>
+#include <wctype.h>
+
+#define WASI_IMPORT(name) \
+  __attribute__((import_module("wasi_snapshot_preview1"), import_name(name)))
+
+typedef struct {
+  const void *buf;
+  size_t len;
+} wasi_ciovec;
+
+WASI_IMPORT("fd_write")
+int wasi_fd_write(int fd, const wasi_ciovec *iovs, size_t len, size_t *written);
+
+WASI_IMPORT("clock_time_get")
+int wasi_clock_time_get(int id, uint64_t precision, uint64_t *time);
+
+// Allocator
+
+// Every block is a power of two in size and starts with a header holding
+// its size class. Freed blocks go on a list per class and are handed out
+// again by the next allocation of that class; memory is never returned.
+
+#define MIN_CLASS 4
+#define NUM_CLASSES 32
+#define HEADER_SIZE 8
+#define PAGE_SIZE 65536
+
+extern unsigned char __heap_base;
+
+typedef struct block {
+  uint32_t class;
+  uint32_t unused;
+} block;
+
+static void *free_lists[NUM_CLASSES];
+static uintptr_t heap_top;
+static uintptr_t heap_end;
+
+static void *heap_grow(size_t size) {
+  if (heap_top == 0) {
+    heap_top = ((uintptr_t)&__heap_base + 7) & ~(uintptr_t)7;
+    heap_end = __builtin_wasm_memory_size(0) * PAGE_SIZE;
+  }
+  if (size > heap_end - heap_top) {
+    size_t pages = (size - (heap_end - heap_top) + PAGE_SIZE - 1) / PAGE_SIZE;
+    if (__builtin_wasm_memory_grow(0, pages) == SIZE_MAX) return NULL;
+    heap_end += pages * PAGE_SIZE;
+  }
+  void *result = (void *)heap_top;
+  heap_top += size;
+  return result;
+}
+
+static unsigned size_class(size_t size) {
+  if (size > ((size_t)1 << (NUM_CLASSES - 1)) - HEADER_SIZE) return NUM_CLASSES;
+  unsigned class = MIN_CLASS;
+  while (((size_t)1 << class) - HEADER_SIZE < size) class++;
+  return class;
+}
+
+void *malloc(size_t size) {
+  unsigned class = size_class(size);
+  if (class >= NUM_CLASSES) return NULL;
+  block *b = free_lists[class];
+  if (b) {
+    free_lists[class] = *(void **)(b + 1);
+  } else {
+    b = heap_grow((size_t)1 << class);
+    if (!b) return NULL;
+    b->class = class;
+  }
+  return b + 1;
+}
+
+void free(void *ptr) {
+  if (!ptr) return;
+  block *b = (block *)ptr - 1;
+  *(void **)ptr = free_lists[b->class];
+  free_lists[b->class] = b;
+}
+
+void *calloc(size_t count, size_t size) {
+  if (size && count > SIZE_MAX / size) return NULL;
+  void *result = malloc(count * size);
+  if (result) memset(result, 0, count * size);
+  return result;
+}
+
+void *realloc(void *ptr, size_t size) {
+  if (!ptr) return malloc(size);
+  block *b = (block *)ptr - 1;
+  size_t capacity = ((size_t)1 << b->class) - HEADER_SIZE;
+  if (size <= capacity) return ptr;
+  void *result = malloc(size);
+  if (!result) return NULL;
+  memcpy(result, ptr, capacity);
+  free(ptr);
+  return result;
+}
+
+// Process
+
+void abort(void) {
+  fflush(stderr);
+  __builtin_trap();
+}
+
+int abs(int x) { return x < 0 ? -x : x; }
+
+long labs(long x) { return x < 0 ? -x : x; }
+
+// Strings
+
+// libc.c is compiled with -fno-builtin, so these loops are not turned back
+// into calls to themselves.
+
+void *memcpy(void *restrict dst, const void *restrict src, size_t n) {
+  unsigned char *d = dst;
+  const unsigned char *s = src;
+  if ((((uintptr_t)d | (uintptr_t)s) & 3) == 0) {
+    for (; n >= 4; n -= 4, d += 4, s += 4) *(uint32_t *)d = *(const uint32_t *)s;
+  }
+  for (; n; n--) *d++ = *s++;
+  return dst;
+}
+
+void *memmove(void *dst, const void *src, size_t n) {
+  unsigned char *d = dst;
+  const unsigned char *s = src;
+  if (d <= s || d >= s + n) return memcpy(dst, src, n);
+  d += n;
+  s += n;
+  if ((((uintptr_t)d | (uintptr_t)s) & 3) == 0) {
+    for (; n >= 4; n -= 4) {
+      d -= 4;
+      s -= 4;
+      *(uint32_t *)d = *(const uint32_t *)s;
+    }
+  }
+  for (; n; n--) *--d = *--s;
+  return dst;
+}
+
+void *memset(void *dst, int c, size_t n) {
+  unsigned char *d = dst;
+  for (; n && ((uintptr_t)d & 3); n--) *d++ = (unsigned char)c;
+  uint32_t word = (unsigned char)c * 0x01010101u;
+  for (; n >= 4; n -= 4, d += 4) *(uint32_t *)d = word;
+  for (; n; n--) *d++ = (unsigned char)c;
+  return dst;
+}
+
+int memcmp(const void *a, const void *b, size_t n) {
+  const unsigned char *x = a, *y = b;
+  for (; n; n--, x++, y++) {
+    if (*x != *y) return *x - *y;
+  }
+  return 0;
+}
+
+void *memchr(const void *s, int c, size_t n) {
+  const unsigned char *p = s;
+  for (; n; n--, p++) {
+    if (*p == (unsigned char)c) return (void *)p;
+  }
+  return NULL;
+}
+
+size_t strlen(const char *s) {
+  const char *p = s;
+  while (*p) p++;
+  return p - s;
+}
+
+int strcmp(const char *a, const char *b) {
+  for (; *a && *a == *b; a++, b++) {}
+  return *(const unsigned char *)a - *(const unsigned char *)b;
+}
+
+int strncmp(const char *a, const char *b, size_t n) {
+  if (!n) return 0;
+  for (; --n && *a && *a == *b; a++, b++) {}
+  return *(const unsigned char *)a - *(const unsigned char *)b;
+}
+
+char *strncpy(char *restrict dst, const char *restrict src, size_t n) {
+  size_t i = 0;
+  for (; i < n && src[i]; i++) dst[i] = src[i];
+  for (; i < n; i++) dst[i] = 0;
+  return dst;
+}
+
+char *strncat(char *restrict dst, const char *restrict src, size_t n) {
+  char *d = dst + strlen(dst);
+  for (; n && *src; n--) *d++ = *src++;
+  *d = 0;
+  return dst;
+}
+
+char *strchr(const char *s, int c) {
+  for (;; s++) {
+    if (*s == (char)c) return (char *)s;
+    if (!*s) return NULL;
+  }
+}
+
+// Characters
+
+// The wide character classes are exact for ASCII and Latin-1; beyond that
+// iswalpha covers the letter blocks of the common scripts, and case mapping
+// covers Latin Extended-A, Greek and Cyrillic.
+
+int isdigit(int c) { return (unsigned)c - '0' < 10; }
+int islower(int c) { return (unsigned)c - 'a' < 26; }
+int isupper(int c) { return (unsigned)c - 'A' < 26; }
+int isalpha(int c) { return islower(c) || isupper(c); }
+int isalnum(int c) { return isalpha(c) || isdigit(c); }
+int isxdigit(int c) { return isdigit(c) || (unsigned)(c | 32) - 'a' < 6; }
+int isprint(int c) { return (unsigned)c - 0x20 < 0x5f; }
+int isspace(int c) { return c == ' ' || (unsigned)c - '\t' < 5; }
+int tolower(int c) { return isupper(c) ? c | 32 : c; }
+int toupper(int c) { return islower(c) ? c & 0x5f : c; }
+
+static const uint32_t letter_ranges[][2] = {
+  {0x00aa, 0x00aa}, {0x00b5, 0x00b5}, {0x00ba, 0x00ba}, {0x00c0, 0x00d6},
+  {0x00d8, 0x00f6}, {0x00f8, 0x02c1}, {0x02c6, 0x02d1}, {0x02e0, 0x02e4},
+  {0x0370, 0x0374}, {0x0376, 0x037d}, {0x0386, 0x0386}, {0x0388, 0x03ff},
+  {0x0400, 0x0481}, {0x048a, 0x052f}, {0x0531, 0x0556}, {0x0561, 0x0587},
+  {0x05d0, 0x05ea}, {0x0620, 0x064a}, {0x0671, 0x06d3}, {0x0904, 0x0939},
+  {0x0e01, 0x0e30}, {0x10a0, 0x10ff}, {0x1100, 0x11ff}, {0x1e00, 0x1fbc},
+  {0x1fc2, 0x1fcc}, {0x1fd0, 0x1fdb}, {0x1fe0, 0x1fec}, {0x1ff2, 0x1ffc},
+  {0x2c00, 0x2ce4}, {0x3041, 0x3096}, {0x30a1, 0x30fa}, {0x3105, 0x312f},
+  {0x3131, 0x318e}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa48c},
+  {0xac00, 0xd7a3}, {0xf900, 0xfa6d}, {0xff21, 0xff3a}, {0xff41, 0xff5a},
+  {0xff66, 0xffdc}, {0x20000, 0x2fa1f},
+};
+
+static bool in_ranges(wint_t c, const uint32_t (*ranges)[2], size_t len) {
+  size_t lo = 0, hi = len;
+  while (lo < hi) {
+    size_t mid = (lo + hi) / 2;
+    if (c < ranges[mid][0]) hi = mid;
+    else if (c > ranges[mid][1]) lo = mid + 1;
+    else return true;
+  }
+  return false;
+}
+
+int iswdigit(wint_t c) { return c - '0' < 10; }
+int iswxdigit(wint_t c) { return c < 128 && isxdigit(c); }
+
+int iswalpha(wint_t c) {
+  if (c < 128) return isalpha(c);
+  return in_ranges(c, letter_ranges, sizeof(letter_ranges) / sizeof(letter_ranges[0]));
+}
+
+int iswalnum(wint_t c) { return iswdigit(c) || iswalpha(c); }
+
+int iswspace(wint_t c) {
+  switch (c) {
+    case ' ': case '\t': case '\n': case '\v': case '\f': case '\r':
+    case 0x85: case 0x1680: case 0x2028: case 0x2029: case 0x205f:
+    case 0x3000:
+      return 1;
+  }
+  return c - 0x2000 < 7 || c - 0x2008 < 3;
+}
+
+int iswblank(wint_t c) {
+  return c == ' ' || c == '\t' || (c > 0x85 && c != 0x2028 && c != 0x2029 && iswspace(c));
+}
+
+wint_t towlower(wint_t c) {
+  if (c < 128) return tolower(c);
+  if (c - 0xc0 < 0x1f && c != 0xd7) return c + 0x20;
+  if (c == 0x130) return 'i';
+  if (c - 0x100 < 0x38 || c - 0x14a < 0x2e) return c | 1;
+  if ((c - 0x139 < 0x10 || c - 0x179 < 6) && c & 1) return c + 1;
+  if (c == 0x178) return 0xff;
+  if (c - 0x391 < 0x19 && c != 0x3a2) return c + 0x20;
+  if (c - 0x410 < 0x20) return c + 0x20;
+  if (c - 0x400 < 0x10) return c + 0x50;
+  return c;
+}
+
+wint_t towupper(wint_t c) {
+  if (c < 128) return toupper(c);
+  if (c - 0xe0 < 0x1f && c != 0xf7) return c - 0x20;
+  if (c == 0xff) return 0x178;
+  if (c == 0x131) return 'I';
+  if (c - 0x100 < 0x38 || c - 0x14a < 0x2e) return c & ~1u;
+  if ((c - 0x139 < 0x10 || c - 0x179 < 6) && !(c & 1)) return c - 1;
+  if (c == 0x3c2) return 0x3a3;
+  if (c - 0x3b1 < 0x19 && c != 0x3c2) return c - 0x20;
+  if (c - 0x430 < 0x20) return c - 0x20;
+  if (c - 0x450 < 0x10) return c - 0x50;
+  return c;
+}
+
+int iswupper(wint_t c) { return towlower(c) != c; }
+int iswlower(wint_t c) { return towupper(c) != c || c == 0xdf; }
+
+// Files
+
+// A FILE is a buffered WASI file descriptor. Closing one frees the buffer
+// but never closes the descriptor, so a grammar or the parser closing its
+// debug stream cannot take stderr down with it.
+
+#define FILE_BUFFER_SIZE 1024
+
+struct FILE {
+  int fd;
+  size_t len;
+  char buf[FILE_BUFFER_SIZE];
+};
+
+static FILE stdout_file = {1, 0, {0}};
+static FILE stderr_file = {2, 0, {0}};
+FILE *const stdout = &stdout_file;
+FILE *const stderr = &stderr_file;
+
+static int fd_write_all(int fd, const char *buf, size_t len) {
+  while (len) {
+    wasi_ciovec iov = {buf, len};
+    size_t written;
+    if (wasi_fd_write(fd, &iov, 1, &written) != 0) return EOF;
+    buf += written;
+    len -= written;
+  }
+  return 0;
+}
+
+int fflush(FILE *f) {
+  if (!f) return 0;
+  int result = fd_write_all(f->fd, f->buf, f->len);
+  f->len = 0;
+  return result;
+}
+
+FILE *fdopen(int fd, const char *mode) {
+  (void)mode;
+  if (fd < 0) return NULL;
+  FILE *f = malloc(sizeof(FILE));
+  if (!f) return NULL;
+  f->fd = fd;
+  f->len = 0;
+  return f;
+}
+
+int fclose(FILE *f) {
+  if (!f) return EOF;
+  int result = fflush(f);
+  if (f != stdout && f != stderr) free(f);
+  return result;
+}
+
+int dup(int fd) { return fd; }
+
+// Output is flushed at each newline, so a stream left open by the parser
+// between parses has written out every complete line.
+static void file_write(FILE *f, const char *s, size_t len) {
+  for (size_t i = 0; i < len; i++) {
+    if (f->len == FILE_BUFFER_SIZE) fflush(f);
+    f->buf[f->len++] = s[i];
+    if (s[i] == '\n') fflush(f);
+  }
+}
+
+size_t fwrite(const void *restrict buf, size_t size, size_t count, FILE *restrict f) {
+  file_write(f, buf, size * count);
+  return count;
+}
+
+int fputc(int c, FILE *f) {
+  char ch = c;
+  file_write(f, &ch, 1);
+  return (unsigned char)c;
+}
+
+int putc(int c, FILE *f) { return fputc(c, f); }
+
+int putchar(int c) { return fputc(c, stdout); }
+
+int fputs(const char *restrict s, FILE *restrict f) {
+  file_write(f, s, strlen(s));
+  return 0;
+}
+
+// Formatting
+
+// format supports the flags, width, precision and length modifiers of C99
+// for the d i u o x X c s p and % conversions. Floating point is not used
+// by the runtime and is not supported.
+
+typedef void (*sink_fn)(void *sink, const char *s, size_t len);
+
+typedef struct {
+  char *buf;
+  size_t size;
+  size_t len;
+} string_sink;
+
+static void string_write(void *sink, const char *s, size_t len) {
+  string_sink *ss = sink;
+  for (size_t i = 0; i < len; i++, ss->len++) {
+    if (ss->len + 1 < ss->size) ss->buf[ss->len] = s[i];
+  }
+}
+
+static void file_sink_write(void *sink, const char *s, size_t len) {
+  file_write(sink, s, len);
+}
+
+static void pad(sink_fn write, void *sink, char c, int n) {
+  for (; n > 0; n--) write(sink, &c, 1);
+}
+
+static int format(sink_fn write, void *sink, const char *fmt, va_list ap) {
+  int total = 0;
+  while (*fmt) {
+    const char *start = fmt;
+    while (*fmt && *fmt != '%') fmt++;
+    if (fmt > start) {
+      write(sink, start, fmt - start);
+      total += fmt - start;
+    }
+    if (!*fmt) break;
+    fmt++;
+
+    bool left = false, zero = false, plus = false, space = false, alt = false;
+    for (;; fmt++) {
+      if (*fmt == '-') left = true;
+      else if (*fmt == '0') zero = true;
+      else if (*fmt == '+') plus = true;
+      else if (*fmt == ' ') space = true;
+      else if (*fmt == '#') alt = true;
+      else break;
+    }
+
+    int width = 0;
+    if (*fmt == '*') {
+      width = va_arg(ap, int);
+      if (width < 0) {
+        left = true;
+        width = -width;
+      }
+      fmt++;
+    } else {
+      while (isdigit(*fmt)) width = width * 10 + (*fmt++ - '0');
+    }
+
+    int precision = -1;
+    if (*fmt == '.') {
+      fmt++;
+      precision = 0;
+      if (*fmt == '*') {
+        precision = va_arg(ap, int);
+        fmt++;
+      } else {
+        while (isdigit(*fmt)) precision = precision * 10 + (*fmt++ - '0');
+      }
+    }
+
+    int length = 0;
+    if (*fmt == 'h') {
+      length = fmt[1] == 'h' ? -2 : -1;
+      fmt += length == -2 ? 2 : 1;
+    } else if (*fmt == 'l') {
+      length = fmt[1] == 'l' ? 2 : 1;
+      fmt += length;
+    } else if (*fmt == 'z' || *fmt == 't') {
+      length = sizeof(size_t) == 8 ? 2 : 1;
+      fmt++;
+    } else if (*fmt == 'j') {
+      length = 2;
+      fmt++;
+    }
+
+    char digits[24];
+    const char *text = digits;
+    size_t text_len = 0;
+    const char *prefix = "";
+    char conv = *fmt ? *fmt++ : 0;
+
+    switch (conv) {
+      case '%':
+        digits[0] = '%';
+        text_len = 1;
+        precision = -1;
+        break;
+      case 'c':
+        digits[0] = (char)va_arg(ap, int);
+        text_len = 1;
+        precision = -1;
+        break;
+      case 's':
+        text = va_arg(ap, const char *);
+        if (!text) text = "(null)";
+        while ((precision < 0 || text_len < (size_t)precision) && text[text_len]) text_len++;
+        precision = -1;
+        break;
+      case 'd':
+      case 'i':
+      case 'u':
+      case 'o':
+      case 'x':
+      case 'X':
+      case 'p': {
+        bool is_signed = conv == 'd' || conv == 'i';
+        uint64_t value;
+        bool negative = false;
+        if (conv == 'p') {
+          value = (uintptr_t)va_arg(ap, void *);
+          prefix = "0x";
+        } else if (is_signed) {
+          int64_t v = length == 2   ? va_arg(ap, long long)
+                      : length == 1 ? va_arg(ap, long)
+                                    : va_arg(ap, int);
+          if (length == -1) v = (short)v;
+          if (length == -2) v = (signed char)v;
+          negative = v < 0;
+          value = negative ? -(uint64_t)v : (uint64_t)v;
+          prefix = negative ? "-" : plus ? "+" : space ? " " : "";
+        } else {
+          value = length == 2   ? va_arg(ap, unsigned long long)
+                  : length == 1 ? va_arg(ap, unsigned long)
+                                : va_arg(ap, unsigned);
+          if (length == -1) value = (unsigned short)value;
+          if (length == -2) value = (unsigned char)value;
+        }
+        unsigned base = conv == 'o' ? 8 : (conv == 'x' || conv == 'X' || conv == 'p') ? 16 : 10;
+        const char *alphabet = conv == 'X' ? "0123456789ABCDEF" : "0123456789abcdef";
+        bool nonzero = value != 0;
+        char *end = digits + sizeof(digits);
+        char *p = end;
+        while (value) {
+          *--p = alphabet[value % base];
+          value /= base;
+        }
+        if (alt && nonzero) {
+          if (conv == 'x') prefix = "0x";
+          if (conv == 'X') prefix = "0X";
+          if (conv == 'o' && *p != '0') *--p = '0';
+        }
+        if (p == end && precision != 0) *--p = '0';
+        text = p;
+        text_len = end - p;
+        break;
+      }
+      default:
+        return -1;
+    }
+
+    size_t prefix_len = strlen(prefix);
+    int zeros = precision > (int)text_len ? precision - (int)text_len : 0;
+    if (zero && !left && precision < 0 && conv != 's' && conv != 'c' && conv != '%') {
+      int fill = width - (int)(prefix_len + text_len);
+      if (fill > zeros) zeros = fill;
+    }
+    int field = prefix_len + zeros + text_len;
+    if (!left) pad(write, sink, ' ', width - field);
+    write(sink, prefix, prefix_len);
+    pad(write, sink, '0', zeros);
+    write(sink, text, text_len);
+    if (left) pad(write, sink, ' ', width - field);
+    total += field > width ? field : width;
+  }
+  return total;
+}
+
+int vsnprintf(char *restrict buf, size_t size, const char *restrict fmt, va_list ap) {
+  string_sink sink = {buf, size, 0};
+  int result = format(string_write, &sink, fmt, ap);
+  if (size) buf[sink.len < size ? sink.len : size - 1] = 0;
+  return result;
+}
+
+int snprintf(char *restrict buf, size_t size, const char *restrict fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  int result = vsnprintf(buf, size, fmt, ap);
+  va_end(ap);
+  return result;
+}
+
+int sprintf(char *restrict buf, const char *restrict fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  int result = vsnprintf(buf, SIZE_MAX, fmt, ap);
+  va_end(ap);
+  return result;
+}
+
+int vfprintf(FILE *restrict f, const char *restrict fmt, va_list ap) {
+  return format(file_sink_write, f, fmt, ap);
+}
+
+int fprintf(FILE *restrict f, const char *restrict fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  int result = vfprintf(f, fmt, ap);
+  va_end(ap);
+  return result;
+}
+
+int printf(const char *restrict fmt, ...) {
+  va_list ap;
+  va_start(ap, fmt);
+  int result = vfprintf(stdout, fmt, ap);
+  va_end(ap);
+  return result;
+}
+
+// Time
+
+int clock_gettime(clockid_t id, struct timespec *ts) {
+  uint64_t now;
+  if (wasi_clock_time_get(id, 1, &now) != 0) return -1;
+  ts->tv_sec = now / 1000000000;
+  ts->tv_nsec = now % 1000000000;
+  return 0;
+}
+
+clock_t clock(void) {
+  uint64_t now;
+  if (wasi_clock_time_get(CLOCK_MONOTONIC, 1000, &now) != 0) return -1;
+  return now / 1000;
+}
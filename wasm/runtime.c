@@ -0,0 +1,52 @@
+// runtime.c builds the tree-sitter runtime as one translation unit, as its
+// lib.c does, so the bindings below can reach its internal functions.
+
+#include "alloc.c"
+#include "get_changed_ranges.c"
+#include "language.c"
+#include "lexer.c"
+#include "node.c"
+#include "parser.c"
+#include "query.c"
+#include "stack.c"
+#include "subtree.c"
+#include "tree.c"
+#include "tree_cursor.c"
+#include "wasm_store.c"
+
+// tsg_parser_has_outstanding_parse tells whether ts_parser_parse returned
+// NULL keeping its partial parse, when it timed out, rather than after
+// resetting the parser, when an external scanner failed.
+bool tsg_parser_has_outstanding_parse(TSParser *self) {
+  return ts_parser_has_outstanding_parse(self);
+}
+
+// tsg_parser_current_byte_offset returns the position of the lexer of a
+// parser, in bytes.
+uint32_t tsg_parser_current_byte_offset(const TSParser *self) {
+  return self->lexer.current_position.bytes;
+}
+
+// host_input_read is the read callback of the inputs parsed by
+// ts_parser_parse: the host reads the text a chunk at a time into payload.
+__attribute__((import_module("treesittergo"), import_name("input_read")))
+const char *host_input_read(void *payload, uint32_t byte, TSPoint position, uint32_t *bytes_read);
+
+// tsg_input_init sets up input to read a UTF-8 text from the host into the
+// buffer at payload.
+void tsg_input_init(TSInput *input, void *payload) {
+  input->payload = payload;
+  input->read = host_input_read;
+  input->encoding = TSInputEncodingUTF8;
+}
+
+// host_log is the log callback of the loggers set with ts_parser_set_logger:
+// the host forwards the message to the logger of the parser.
+__attribute__((import_module("treesittergo"), import_name("log")))
+void host_log(void *payload, TSLogType log_type, const char *buffer);
+
+// tsg_logger_init sets up logger to send the log of a parser to the host.
+void tsg_logger_init(TSLogger *logger) {
+  logger->payload = NULL;
+  logger->log = host_log;
+}
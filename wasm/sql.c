@@ -0,0 +1,14 @@
+// sql.c builds the SQL grammar and its external scanner.
+
+#include "sql/parser.c"
+
+// The scanner defines its destroy function returning void *, while the
+// grammar calls it through a pointer returning void. A wasm call_indirect
+// traps on that mismatch, so the scanner's definition is wrapped.
+#define tree_sitter_sql_external_scanner_destroy scanner_destroy
+#include "sql/scanner.c"
+#undef tree_sitter_sql_external_scanner_destroy
+
+void tree_sitter_sql_external_scanner_destroy(void *payload) {
+  scanner_destroy(payload);
+}